@@ -6,10 +6,14 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/PiranhaCodes/webpty-pty/internal/api"
+	"github.com/PiranhaCodes/webpty-pty/internal/config"
 	"github.com/PiranhaCodes/webpty-pty/internal/pty"
 )
 
@@ -35,7 +39,18 @@ func expandPath(path string) (string, error) {
 	return path, nil
 }
 
+// lookupUser looks up a user by name or, failing that, by numeric UID.
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if _, numeric := strconv.Atoi(name); err != nil && numeric == nil {
+		u, err = user.LookupId(name)
+	}
+	return u, err
+}
+
 func main() {
+	pty.RunHelper()
+
 	cfgpath := flag.String("config", "~/.webpty/config.yml", "Path to configuration file")
 	socketPathRaw := flag.String("socket", "~/.webpty/pty.sock", "Path to Unix socket")
 	flag.Parse()
@@ -75,12 +90,21 @@ func main() {
 		log.Fatalf("[PTY] Failed to create log directory: %v", err)
 	}
 
+	cfg := config.Default()
 	if _, err := os.Stat(cfgPathExpanded); err == nil {
-		log.Printf("[PTY] Config file found at %s (using defaults for now)", cfgPathExpanded)
+		cfg, err = config.Load(cfgPathExpanded)
+		if err != nil {
+			log.Fatalf("[PTY] Failed to load config: %v", err)
+		}
+		log.Printf("[PTY] Loaded config from %s", cfgPathExpanded)
 	} else {
 		log.Printf("[PTY] Config file not found at %s, using defaults", cfgPathExpanded)
 	}
 
+	pty.DefaultLogPolicy = cfg.LogPolicy()
+	pty.DefaultSessionPolicy = cfg.SessionPolicy()
+	stopJanitor := pty.StartLogJanitor(logDir, pty.DefaultLogPolicy)
+
 	server := api.NewServer(socketPath)
 
 	go func() {
@@ -89,12 +113,45 @@ func main() {
 		}
 	}()
 
+	var httpServer *api.HTTPServer
+	if cfg.HTTP.Listen != "" {
+		listen := cfg.HTTP.Listen
+		if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+			path, err = expandPath(path)
+			if err != nil {
+				log.Fatalf("[PTY] Failed to expand HTTP socket path: %v", err)
+			}
+			listen = "unix:" + path
+		}
+		opts := api.HTTPOptions{
+			Listen:         listen,
+			Token:          cfg.HTTP.Token,
+			AllowedOrigins: cfg.HTTP.AllowedOrigins,
+		}
+		if cfg.HTTP.User != "" {
+			opts.User, err = lookupUser(cfg.HTTP.User)
+			if err != nil {
+				log.Fatalf("[PTY] Failed to look up http.user: %v", err)
+			}
+		}
+		httpServer = api.NewHTTPServer(server, opts)
+		go func() {
+			if err := httpServer.Start(); err != nil {
+				log.Fatalf("[PTY] Failed to start HTTP server: %v", err)
+			}
+		}()
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
 
 	log.Println("[PTY] Shutting down server...")
+	stopJanitor()
 	pty.CleanupAllSessions()
+	if httpServer != nil {
+		httpServer.Stop()
+	}
 	server.Stop()
 	log.Println("[PTY] Server shutdown complete")
 }
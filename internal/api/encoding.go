@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/base64"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+)
+
+// Encodings of binary data in JSON strings.
+const (
+	// EncodingUTF8 sends data as text. Bytes that are not valid UTF-8 are
+	// replaced with U+FFFD.
+	EncodingUTF8 = "utf8"
+	// EncodingBase64 sends data base64-encoded, preserving every byte.
+	EncodingBase64 = "base64"
+)
+
+var errInvalidEncoding = pty.InvalidField("encoding", "encoding must be utf8 or base64")
+
+// checkEncoding validates an encoding and returns it with the default
+// applied.
+func checkEncoding(encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return EncodingUTF8, nil
+	case EncodingUTF8, EncodingBase64:
+		return encoding, nil
+	default:
+		return "", errInvalidEncoding
+	}
+}
+
+// decodeData returns the bytes carried by data in the given encoding.
+func decodeData(data, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", EncodingUTF8:
+		return []byte(data), nil
+	case EncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, pty.InvalidField("data", "invalid base64 data: %v", err)
+		}
+		return b, nil
+	default:
+		return nil, errInvalidEncoding
+	}
+}
+
+// outputEncoder encodes a stream of output chunks. In utf8 encoding, a
+// character split across chunks is held back until it is complete, so it is
+// never broken in two.
+type outputEncoder struct {
+	encoding string
+	pending  []byte
+}
+
+// encode returns data encoded, together with the offset just past the bytes
+// it covers, given that data ends at offset. It returns an empty string if
+// there is nothing to send yet.
+func (e *outputEncoder) encode(data []byte, offset int64) (string, int64) {
+	if e.encoding == EncodingBase64 {
+		return base64.StdEncoding.EncodeToString(data), offset
+	}
+	if len(e.pending) > 0 {
+		data = append(e.pending, data...)
+	}
+	n := len(data) - vt.IncompleteUTF8(data)
+	e.pending = append([]byte(nil), data[n:]...)
+	return string(data[:n]), offset - int64(len(e.pending))
+}
@@ -0,0 +1,79 @@
+package api
+
+import "testing"
+
+func TestOutputEncoder(t *testing.T) {
+	type frame struct {
+		data   string
+		offset int64
+	}
+	tests := []struct {
+		name     string
+		encoding string
+		chunks   []string
+		want     []frame
+	}{
+		{
+			name:     "base64",
+			encoding: EncodingBase64,
+			chunks:   []string{"h\xc3", "\xa9"},
+			want:     []frame{{"aMM=", 2}, {"qQ==", 3}},
+		},
+		{
+			name:     "ascii",
+			encoding: EncodingUTF8,
+			chunks:   []string{"abc", "de"},
+			want:     []frame{{"abc", 3}, {"de", 5}},
+		},
+		{
+			name:     "two byte split",
+			encoding: EncodingUTF8,
+			chunks:   []string{"h\xc3", "\xa9llo"},
+			want:     []frame{{"h", 1}, {"éllo", 6}},
+		},
+		{
+			name:     "three byte split in three",
+			encoding: EncodingUTF8,
+			chunks:   []string{"\xe2", "\x82", "\xac!"},
+			want:     []frame{{"", 0}, {"", 0}, {"€!", 4}},
+		},
+		{
+			name:     "four byte split",
+			encoding: EncodingUTF8,
+			chunks:   []string{"a\xf0\x9f", "\x98\x80b"},
+			want:     []frame{{"a", 1}, {"😀b", 6}},
+		},
+		{
+			name:     "complete character at the end",
+			encoding: EncodingUTF8,
+			chunks:   []string{"a€"},
+			want:     []frame{{"a€", 4}},
+		},
+		{
+			name:     "invalid bytes are not held",
+			encoding: EncodingUTF8,
+			chunks:   []string{"a\xff", "\x80b"},
+			want:     []frame{{"a\xff", 2}, {"\x80b", 4}},
+		},
+		{
+			name:     "truncated sequence followed by ascii",
+			encoding: EncodingUTF8,
+			chunks:   []string{"a\xe2", "b"},
+			want:     []frame{{"a", 1}, {"\xe2b", 3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &outputEncoder{encoding: tt.encoding}
+			var offset int64
+			for i, c := range tt.chunks {
+				offset += int64(len(c))
+				data, end := e.encode([]byte(c), offset)
+				if data != tt.want[i].data || end != tt.want[i].offset {
+					t.Errorf("chunk %d: encode(%q, %d) = %q, %d; want %q, %d",
+						i, c, offset, data, end, tt.want[i].data, tt.want[i].offset)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+// errSessionIDRequired is returned by every action that needs a session ID
+// and did not get one.
+var errSessionIDRequired = pty.InvalidField("id", "session ID is required")
+
+// errorResponse returns the response to a request that failed with err,
+// with the code and details of err if it is a *pty.Error and INTERNAL
+// otherwise.
+func errorResponse(err error) Response {
+	return Response{
+		Ok:      false,
+		Err:     err.Error(),
+		Code:    string(pty.CodeOf(err)),
+		Details: pty.DetailsOf(err),
+	}
+}
+
+// invalidRequest returns the error for request data of an action that is
+// not valid JSON for its type.
+func invalidRequest(action string, err error) error {
+	return pty.WrapError(pty.CodeInvalidArgument, err, "invalid "+action+" request")
+}
+
+// httpStatus maps an error code to an HTTP status.
+func httpStatus(code pty.Code) int {
+	switch code {
+	case pty.CodeNotFound:
+		return http.StatusNotFound
+	case pty.CodeInvalidArgument:
+		return http.StatusBadRequest
+	case pty.CodePermissionDenied:
+		return http.StatusForbidden
+	case pty.CodeQuotaExceeded:
+		return http.StatusTooManyRequests
+	case pty.CodeSessionExited:
+		return http.StatusGone
+	case pty.CodeBusy:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
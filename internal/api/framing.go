@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+// Framing modes of a socket connection.
+const (
+	FramingJSON   = "json"
+	FramingBinary = "binary"
+)
+
+// Binary frame types.
+const (
+	// frameJSON carries a JSON request, response or frame.
+	frameJSON byte = 0
+	// frameOutput carries raw output of the session with the frame's index,
+	// from the server to the client.
+	frameOutput byte = 1
+	// frameInput carries raw input for the session with the frame's index,
+	// from the client to the server.
+	frameInput byte = 2
+)
+
+const (
+	// frameHeaderSize is the size of a binary frame header: the type byte,
+	// the big-endian uint16 session index and the big-endian uint32 payload
+	// length.
+	frameHeaderSize = 7
+	// maxFramePayload bounds the payload of frames received from clients.
+	maxFramePayload = 1 << 20
+)
+
+var errTooManySessions = pty.NewError(pty.CodeQuotaExceeded, "too many sessions on this connection")
+
+// writeFrame writes a binary frame in a single call.
+func writeFrame(w io.Writer, typ byte, index uint16, payload []byte) error {
+	buf := make([]byte, frameHeaderSize+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint16(buf[1:3], index)
+	binary.BigEndian.PutUint32(buf[3:7], uint32(len(payload)))
+	copy(buf[frameHeaderSize:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readFrame reads a binary frame.
+func readFrame(r io.Reader) (byte, uint16, []byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[3:7])
+	if length > maxFramePayload {
+		return 0, 0, nil, fmt.Errorf("frame payload of %d bytes exceeds %d", length, maxFramePayload)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, nil, err
+	}
+	return header[0], binary.BigEndian.Uint16(header[1:3]), payload, nil
+}
+
+// sessionIndexLocked returns the index that identifies the session with the
+// given ID in the binary frames of the connection, assigning the next one on
+// first use. Indexes start at 1 and are never reused on a connection. c.mu
+// must be held.
+func (c *clientConn) sessionIndexLocked(id string) (uint16, error) {
+	if index, ok := c.indexes[id]; ok {
+		return index, nil
+	}
+	if len(c.indexes) == 1<<16-1 {
+		return 0, errTooManySessions
+	}
+	index := uint16(len(c.indexes) + 1)
+	c.indexes[id] = index
+	c.sessions[index] = id
+	return index, nil
+}
+
+// serveBinary reads binary frames from r until the connection fails. The
+// whitespace that ended the framing request is skipped first; no frame type
+// is a whitespace character.
+func (s *Server) serveBinary(c *clientConn, r *bufio.Reader) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil || (b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n') {
+			break
+		}
+		r.ReadByte()
+	}
+
+	for {
+		typ, index, payload, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				c.send(errorResponse(pty.WrapError(pty.CodeInvalidArgument, err, "invalid frame")))
+			}
+			return
+		}
+
+		switch typ {
+		case frameJSON:
+			var req Request
+			if err := json.Unmarshal(payload, &req); err != nil {
+				c.send(errorResponse(pty.WrapError(pty.CodeInvalidArgument, err, "invalid request")))
+				continue
+			}
+			s.dispatch(c, req)
+		case frameInput:
+			s.handleInputFrame(c, index, payload)
+		default:
+			c.send(errorResponse(pty.NewError(pty.CodeInvalidArgument, "invalid frame: unknown type %d", typ)))
+			return
+		}
+	}
+}
+
+// handleInputFrame writes the payload of an input frame to its session.
+// Input frames are not acknowledged; failures are reported with an error
+// frame.
+func (s *Server) handleInputFrame(c *clientConn, index uint16, payload []byte) {
+	c.mu.Lock()
+	id, ok := c.sessions[index]
+	c.mu.Unlock()
+	if !ok {
+		c.send(Frame{Type: "error", Reason: fmt.Sprintf("unknown session index %d", index), Code: string(pty.CodeNotFound)})
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(id)
+	if err != nil {
+		c.send(errorFrame(id, err))
+		return
+	}
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorFrame(id, pty.ErrPermissionDenied))
+		return
+	}
+	if _, err := sess.Write(payload); err != nil {
+		c.send(errorFrame(id, err))
+	}
+}
+
+// errorFrame returns the error frame reporting err for a session.
+func errorFrame(id string, err error) Frame {
+	return Frame{Type: "error", ID: id, Reason: err.Error(), Code: string(pty.CodeOf(err))}
+}
+
+func (s *Server) handleFraming(c *clientConn, data json.RawMessage) {
+	var req FramingRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("framing", err)))
+		return
+	}
+
+	if req.Mode != FramingJSON && req.Mode != FramingBinary {
+		c.send(errorResponse(pty.InvalidField("mode", "mode must be json or binary")))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp := Response{Ok: true}
+	switch {
+	case req.Mode == FramingBinary && !c.binaryAllowed:
+		resp = errorResponse(pty.NewError(pty.CodeInvalidArgument, "binary framing is only available on the UNIX socket"))
+	case req.Mode == FramingJSON && c.binary:
+		resp = errorResponse(pty.NewError(pty.CodeInvalidArgument, "framing cannot be changed back to json"))
+	}
+	// The response is the last message in the old framing.
+	c.writeLocked(resp)
+	if resp.Ok && req.Mode == FramingBinary {
+		c.binary = true
+	}
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	header := func(typ byte, index uint16, length uint32) []byte {
+		return []byte{typ, byte(index >> 8), byte(index), byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)}
+	}
+	tests := []struct {
+		name      string
+		input     []byte
+		wantType  byte
+		wantIndex uint16
+		wantData  []byte
+		wantErr   error
+		tooLarge  bool
+	}{
+		{
+			name:      "input frame",
+			input:     append(header(frameInput, 3, 2), "ls"...),
+			wantType:  frameInput,
+			wantIndex: 3,
+			wantData:  []byte("ls"),
+		},
+		{
+			name:      "empty payload",
+			input:     header(frameJSON, 0, 0),
+			wantType:  frameJSON,
+			wantIndex: 0,
+			wantData:  []byte{},
+		},
+		{
+			name:      "largest payload",
+			input:     append(header(frameInput, 0xffff, maxFramePayload), make([]byte, maxFramePayload)...),
+			wantType:  frameInput,
+			wantIndex: 0xffff,
+			wantData:  make([]byte, maxFramePayload),
+		},
+		{
+			name:    "no data",
+			input:   nil,
+			wantErr: io.EOF,
+		},
+		{
+			name:    "truncated header",
+			input:   header(frameInput, 1, 2)[:4],
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "missing payload",
+			input:   header(frameInput, 1, 5),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "truncated payload",
+			input:   append(header(frameInput, 1, 5), "ab"...),
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:     "oversized payload",
+			input:    header(frameInput, 1, maxFramePayload+1),
+			tooLarge: true,
+		},
+		{
+			name:     "maximum length",
+			input:    header(frameInput, 1, 0xffffffff),
+			tooLarge: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ, index, data, err := readFrame(bytes.NewReader(tt.input))
+			if tt.tooLarge {
+				if err == nil || !strings.Contains(err.Error(), "exceeds") {
+					t.Errorf("readFrame() error = %v, want a size error", err)
+				}
+				return
+			}
+			if tt.wantErr != nil {
+				if err != tt.wantErr {
+					t.Errorf("readFrame() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readFrame() error = %v", err)
+			}
+			if typ != tt.wantType || index != tt.wantIndex || !bytes.Equal(data, tt.wantData) {
+				t.Errorf("readFrame() = %d, %d, %d bytes; want %d, %d, %d bytes",
+					typ, index, len(data), tt.wantType, tt.wantIndex, len(tt.wantData))
+			}
+		})
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, frameOutput, 7, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{frameOutput, 0, 7, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("writeFrame() wrote %v, want %v", buf.Bytes(), want)
+	}
+	typ, index, data, err := readFrame(&buf)
+	if err != nil || typ != frameOutput || index != 7 || string(data) != "hello" {
+		t.Errorf("readFrame() = %d, %d, %q, %v", typ, index, data, err)
+	}
+}
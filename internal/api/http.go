@@ -0,0 +1,324 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+	"github.com/gorilla/websocket"
+)
+
+// wsPingInterval is how often idle WebSocket connections are pinged so that
+// proxies keep them open and dead peers are noticed.
+const wsPingInterval = 30 * time.Second
+
+// HTTPOptions configures the HTTP listener.
+type HTTPOptions struct {
+	// Listen is a host:port on a loopback address, or "unix:" followed by
+	// the path of a UNIX socket.
+	Listen string
+	// Token, if set, must accompany every request, either as a bearer token
+	// in the Authorization header or as the token query parameter.
+	Token string
+	// AllowedOrigins lists the browser origins, besides the listener's own,
+	// that may open WebSockets. "*" allows any origin.
+	AllowedOrigins []string
+	// User is the user that clients on a TCP port act as. Without it, TCP
+	// clients are refused.
+	User *user.User
+}
+
+// HTTPServer serves the protocol over WebSocket and a REST API, next to the
+// UNIX socket of a Server.
+type HTTPServer struct {
+	server   *Server
+	opts     HTTPOptions
+	mux      *http.ServeMux
+	http     *http.Server
+	upgrader websocket.Upgrader
+}
+
+// credsKey is the context key of the peer credentials of an HTTP
+// connection.
+type credsKey struct{}
+
+// NewHTTPServer creates an HTTP server that dispatches requests to server.
+func NewHTTPServer(server *Server, opts HTTPOptions) *HTTPServer {
+	h := &HTTPServer{
+		server: server,
+		opts:   opts,
+		mux:    http.NewServeMux(),
+	}
+	h.upgrader = websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	h.http = &http.Server{
+		Handler:     h.mux,
+		ConnContext: h.connContext,
+	}
+
+	h.mux.HandleFunc("GET /ws", h.authorize(h.handleWS))
+	h.mux.HandleFunc("GET /ws/sessions/{id}", h.authorize(h.handleTerminal))
+	h.registerREST()
+	return h
+}
+
+// Start listens on the configured address and serves requests until Stop
+// is called.
+func (h *HTTPServer) Start() error {
+	network, addr := "tcp", h.opts.Listen
+	if path, ok := strings.CutPrefix(h.opts.Listen, "unix:"); ok {
+		network, addr = "unix", path
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("[PTY] HTTP server listening on %s", h.opts.Listen)
+
+	if err := h.http.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop closes the listener and all connections.
+func (h *HTTPServer) Stop() {
+	h.http.Close()
+	log.Println("[PTY] HTTP server stopped")
+}
+
+// connContext records who is on the other end of a connection. Over a UNIX
+// socket that is the peer process; over TCP the peer cannot be identified
+// and acts as the configured user.
+func (h *HTTPServer) connContext(ctx context.Context, conn net.Conn) context.Context {
+	if _, ok := conn.(*net.UnixConn); !ok {
+		if h.opts.User == nil {
+			return ctx
+		}
+		uid, _ := strconv.Atoi(h.opts.User.Uid)
+		gid, _ := strconv.Atoi(h.opts.User.Gid)
+		creds := peerCreds{UID: uid, GID: gid, PID: -1}
+		return context.WithValue(ctx, credsKey{}, &creds)
+	}
+	creds, err := peerCredentials(conn)
+	if err != nil {
+		log.Printf("[PTY] Failed to read HTTP peer credentials: %v", err)
+		return ctx
+	}
+	return context.WithValue(ctx, credsKey{}, &creds)
+}
+
+// authorize checks the token and the peer credentials before calling next.
+func (h *HTTPServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.opts.Token != "" {
+			token := r.URL.Query().Get("token")
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				token = bearer
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(h.opts.Token)) != 1 {
+				http.Error(w, "invalid token", http.StatusUnauthorized)
+				return
+			}
+		}
+		if _, ok := r.Context().Value(credsKey{}).(*peerCreds); !ok {
+			http.Error(w, "failed to read peer credentials", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func requestCreds(r *http.Request) peerCreds {
+	return *r.Context().Value(credsKey{}).(*peerCreds)
+}
+
+// checkOrigin lets WebSockets be opened, and REST requests other than GET be
+// made, by non-browser clients, pages served from the listener itself and the
+// configured origins.
+func (h *HTTPServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.opts.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsWriter sends every write as one WebSocket text message. A json.Encoder
+// writes each value in a single call, so every response and frame becomes
+// one message.
+type wsWriter struct {
+	ws *websocket.Conn
+}
+
+func (w wsWriter) Write(p []byte) (int, error) {
+	if err := w.ws.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// keepAlive pings ws until done is closed.
+func keepAlive(ws *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsPingInterval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handleWS speaks the socket protocol over a WebSocket: every text message
+// is a request, and responses and frames are sent back as text messages.
+func (h *HTTPServer) handleWS(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go keepAlive(ws, done)
+
+	c := newClientConn(wsWriter{ws: ws})
+	c.creds = requestCreds(r)
+	defer c.detachAll()
+
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			c.send(errorResponse(pty.WrapError(pty.CodeInvalidArgument, err, "invalid request")))
+			continue
+		}
+		h.server.dispatch(c, req)
+	}
+}
+
+// handleTerminal attaches a WebSocket to a session as a raw terminal, the
+// way xterm.js's attach addon expects: output is sent as binary messages
+// and every message received is typed into the session. The optional since,
+// cols and rows query parameters set the resume offset and terminal size.
+func (h *HTTPServer) handleTerminal(w http.ResponseWriter, r *http.Request) {
+	creds := requestCreds(r)
+	id := r.PathValue("id")
+
+	sess, err := pty.DefaultManager.Lookup(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !sess.Authorized(creds.UID) {
+		writeError(w, pty.ErrPermissionDenied)
+		return
+	}
+
+	query := r.URL.Query()
+	since := int64(-1)
+	if v := query.Get("since"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeError(w, pty.InvalidField("since", "invalid since: %v", err))
+			return
+		}
+		since = n
+	}
+	cols, _ := strconv.Atoi(query.Get("cols"))
+	rows, _ := strconv.Atoi(query.Get("rows"))
+	if err := pty.CheckSize(cols, rows); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	if cols > 0 && rows > 0 {
+		if err := sess.Resize(cols, rows); err != nil {
+			log.Printf("[PTY] Session %s: resize on attach failed: %v", id, err)
+		}
+	}
+
+	client, restore, _, err := sess.Attach(creds.UID, creds.PID, since)
+	if err != nil {
+		ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+		return
+	}
+
+	go func() {
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				client.Detach()
+				return
+			}
+			if _, err := sess.Write(msg); err != nil {
+				client.Detach()
+				return
+			}
+		}
+	}()
+	go keepAlive(ws, client.Done())
+
+	if len(restore) > 0 {
+		if err := ws.WriteMessage(websocket.BinaryMessage, restore); err != nil {
+			client.Detach()
+			return
+		}
+	}
+
+	for {
+		select {
+		case out := <-client.Output():
+			if err := ws.WriteMessage(websocket.BinaryMessage, out.Data); err != nil {
+				client.Detach()
+				return
+			}
+		case <-client.Done():
+		drain:
+			for {
+				select {
+				case out := <-client.Output():
+					ws.WriteMessage(websocket.BinaryMessage, out.Data)
+				default:
+					break drain
+				}
+			}
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, client.Err().Error()))
+			return
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package api
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+)
 
 // Request represents an incoming request over the UNIX socket.
 type Request struct {
@@ -8,25 +12,68 @@ type Request struct {
 	Data   json.RawMessage `json:"data"`
 }
 
-// Response represents a response to a request.
+// Response represents a response to a request. A failed response carries
+// the error message in Err, a stable error code in Code and, for some
+// errors, machine-readable Details.
 type Response struct {
-	Ok   bool        `json:"ok"`
-	Err  string      `json:"err,omitempty"`
-	Data interface{} `json:"data,omitempty"`
+	Ok      bool                   `json:"ok"`
+	Err     string                 `json:"err,omitempty"`
+	Code    string                 `json:"code,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	Data    interface{}            `json:"data,omitempty"`
+}
+
+// HelloRequest is the data for a hello action. ProtocolVersion is the
+// "major.minor" version the client speaks; the request fails if the server
+// does not speak the same major version.
+type HelloRequest struct {
+	ProtocolVersion string `json:"protocol_version,omitempty"`
+}
+
+// HelloResponse is the data returned from a hello action.
+type HelloResponse struct {
+	ProtocolVersion string    `json:"protocol_version"`
+	ServerVersion   string    `json:"server_version"`
+	Build           BuildInfo `json:"build"`
+	Actions         []string  `json:"actions"`
+	Capabilities    []string  `json:"capabilities"`
+}
+
+// BuildInfo describes the server binary.
+type BuildInfo struct {
+	GoVersion string `json:"go_version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
 }
 
 // SpawnRequest is the data for a spawn action.
-type SpawnRequest struct{}
+type SpawnRequest struct {
+	Cols        int               `json:"cols,omitempty"`
+	Rows        int               `json:"rows,omitempty"`
+	Emulator    bool              `json:"emulator,omitempty"`
+	Record      bool              `json:"record,omitempty"`
+	RecordInput bool              `json:"record_input,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	CwdFrom     string            `json:"cwd_from,omitempty"`
+	User        string            `json:"user,omitempty"`
+
+	ShellIntegration bool `json:"shell_integration,omitempty"`
+}
 
 // SpawnResponse is the data returned from a spawn action.
+// ShellIntegration reports whether the shell integration was loaded.
 type SpawnResponse struct {
-	ID string `json:"id"`
+	ID               string `json:"id"`
+	ShellIntegration bool   `json:"shell_integration,omitempty"`
 }
 
-// WriteRequest is the data for a write action.
+// WriteRequest is the data for a write action. Encoding is "utf8"
+// (default) or "base64"; use base64 to send bytes that are not valid UTF-8.
 type WriteRequest struct {
-	ID   string `json:"id"`
-	Data string `json:"data"`
+	ID       string `json:"id"`
+	Data     string `json:"data"`
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // ResizeRequest is the data for a resize action.
@@ -36,9 +83,11 @@ type ResizeRequest struct {
 	Rows int    `json:"rows"`
 }
 
-// KillRequest is the data for a kill action.
+// KillRequest is the data for a kill action. Force kills the session even
+// while a job runs in the foreground.
 type KillRequest struct {
-	ID string `json:"id"`
+	ID    string `json:"id"`
+	Force bool   `json:"force,omitempty"`
 }
 
 // ListResponse is the data returned from a list action.
@@ -49,6 +98,299 @@ type ListResponse struct {
 
 // SessionInfo contains information about a session.
 type SessionInfo struct {
+	ID         string            `json:"id"`
+	Status     string            `json:"status"` // "active" or "exiting"
+	State      string            `json:"state"`  // "attached" or "detached"
+	Owner      int               `json:"owner"`
+	User       string            `json:"user,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Title      string            `json:"title,omitempty"`
+	Cwd        string            `json:"cwd,omitempty"`
+	DetachedAt string            `json:"detached_at,omitempty"`
+	Clients    []ClientInfo      `json:"clients"`
+	Usage      *UsageInfo        `json:"usage,omitempty"`
+}
+
+// UsageInfo is the resource usage of a session's cgroup. Memory values are
+// in bytes and CPU in seconds.
+type UsageInfo struct {
+	Memory     int64   `json:"memory"`
+	MemoryPeak int64   `json:"memory_peak"`
+	Pids       int64   `json:"pids"`
+	CPU        float64 `json:"cpu"`
+	OOMKills   int64   `json:"oom_kills"`
+}
+
+// ClientInfo describes a client attached to a session.
+type ClientInfo struct {
+	ID         string `json:"id"`
+	UID        int    `json:"uid"`
+	PID        int    `json:"pid"`
+	AttachedAt string `json:"attached_at"`
+}
+
+// GetRequest is the data for a get action.
+type GetRequest struct {
+	ID string `json:"id"`
+}
+
+// AttachRequest is the data for an attach action. Since is the offset to
+// resume from; when omitted the whole scrollback buffer is replayed.
+// Encoding selects the encoding of output frames, "utf8" (default) or
+// "base64".
+type AttachRequest struct {
+	ID       string `json:"id"`
+	Since    *int64 `json:"since,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// AttachResponse is the data returned from an attach action.
+type AttachResponse struct {
+	ID       string `json:"id"`
+	ClientID string `json:"client_id"`
+	Offset   int64  `json:"offset"`
+	// Index identifies the session in binary frames, on connections that
+	// use binary framing.
+	Index int `json:"index,omitempty"`
+}
+
+// DetachRequest is the data for a detach action. ClientID selects another
+// attached client; when empty the connection's own attachment is detached.
+type DetachRequest struct {
+	ID       string `json:"id"`
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// SnapshotRequest is the data for a snapshot action.
+type SnapshotRequest struct {
 	ID     string `json:"id"`
-	Status string `json:"status"` // "active" or "exiting"
+	Format string `json:"format,omitempty"` // "text" (default), "cells" or "ansi"
+}
+
+// SnapshotResponse is the data returned from a snapshot action. Exactly one
+// of Text, Cells and ANSI is set, depending on the requested format.
+type SnapshotResponse struct {
+	ID            string          `json:"id"`
+	Format        string          `json:"format"`
+	Cols          int             `json:"cols"`
+	Rows          int             `json:"rows"`
+	CursorX       int             `json:"cursor_x"`
+	CursorY       int             `json:"cursor_y"`
+	CursorVisible bool            `json:"cursor_visible"`
+	AltScreen     bool            `json:"alt_screen"`
+	ScrollTop     int             `json:"scroll_top"`
+	ScrollBottom  int             `json:"scroll_bottom"`
+	Text          string          `json:"text,omitempty"`
+	Cells         [][]vt.CellJSON `json:"cells,omitempty"`
+	ANSI          string          `json:"ansi,omitempty"`
+}
+
+// ReplayRequest is the data for a replay action. Times are in seconds.
+type ReplayRequest struct {
+	ID       string  `json:"id"`
+	Speed    float64 `json:"speed,omitempty"`
+	Seek     float64 `json:"seek,omitempty"`
+	MaxIdle  float64 `json:"max_idle,omitempty"`
+	Encoding string  `json:"encoding,omitempty"`
+}
+
+// ReplayResponse is the data returned from a replay action before the
+// recording is streamed.
+type ReplayResponse struct {
+	ID    string `json:"id"`
+	Cols  int    `json:"cols"`
+	Rows  int    `json:"rows"`
+	Index int    `json:"index,omitempty"`
+}
+
+// FramingRequest is the data for a framing action. Mode is "json" or
+// "binary".
+type FramingRequest struct {
+	Mode string `json:"mode"`
+}
+
+// SearchRequest is the data for a search action. Without an ID, every
+// session of the user with the given UID is searched, running or ended.
+type SearchRequest struct {
+	ID         string   `json:"id,omitempty"`
+	UID        *int     `json:"uid,omitempty"`
+	Query      string   `json:"query"`
+	Regex      bool     `json:"regex,omitempty"`
+	IgnoreCase bool     `json:"ignore_case,omitempty"`
+	Sources    []string `json:"sources,omitempty"`
+	Limit      int      `json:"limit,omitempty"`
+}
+
+// SearchResponse is the data returned from a search action.
+type SearchResponse struct {
+	Matches   []SearchMatch `json:"matches"`
+	Count     int           `json:"count"`
+	Truncated bool          `json:"truncated"`
+}
+
+// SearchMatch is a line of session output that matched a search.
+type SearchMatch struct {
+	ID     string `json:"id"`
+	Source string `json:"source"` // "scrollback" or "log"
+	Offset int64  `json:"offset"`
+	Time   string `json:"time,omitempty"`
+	Line   string `json:"line"`
+}
+
+// WatchRequest is the data for a watch action.
+type WatchRequest struct {
+	ID       string  `json:"id"`
+	Pattern  string  `json:"pattern"`
+	Response string  `json:"response,omitempty"`
+	Repeat   bool    `json:"repeat,omitempty"`
+	Timeout  float64 `json:"timeout,omitempty"`
+	Since    *int64  `json:"since,omitempty"`
+}
+
+// WatchResponse is the data returned from a watch action.
+type WatchResponse struct {
+	ID      string `json:"id"`
+	WatchID string `json:"watch_id"`
+}
+
+// UnwatchRequest is the data for an unwatch action.
+type UnwatchRequest struct {
+	ID      string `json:"id"`
+	WatchID string `json:"watch_id"`
+}
+
+// ExecRequest is the data for an exec action.
+type ExecRequest struct {
+	Command   []string `json:"command"`
+	Stdin     string   `json:"stdin,omitempty"`
+	EOF       bool     `json:"eof,omitempty"`
+	Timeout   float64  `json:"timeout,omitempty"`
+	MaxOutput int      `json:"max_output,omitempty"`
+	Strip     bool     `json:"strip,omitempty"`
+	Cols      int      `json:"cols,omitempty"`
+	Rows      int      `json:"rows,omitempty"`
+}
+
+// ExecResponse is the data returned from an exec action.
+type ExecResponse struct {
+	ID        string  `json:"id"`
+	Output    string  `json:"output"`
+	Truncated bool    `json:"truncated"`
+	ExitCode  int     `json:"exit_code"`
+	Signal    string  `json:"signal,omitempty"`
+	TimedOut  bool    `json:"timed_out"`
+	Duration  float64 `json:"duration"`
+}
+
+// Frame is a message pushed to an attached connection outside of the
+// request/response cycle. Frames carry a "type" field, responses do not.
+// Output frames carry the encoding of their data.
+type Frame struct {
+	Type     string   `json:"type"` // "output", "resize", "detached", "exit", "match", "watch_end" or "error"
+	ID       string   `json:"id"`
+	Offset   int64    `json:"offset"`
+	Data     string   `json:"data,omitempty"`
+	Encoding string   `json:"encoding,omitempty"`
+	Cols     int      `json:"cols,omitempty"`
+	Rows     int      `json:"rows,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Code     string   `json:"code,omitempty"`
+	Watch    string   `json:"watch,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+}
+
+// SubscribeRequest is the data for a subscribe action. Without ID or
+// Labels, the events of every session the client may attach to are
+// delivered.
+type SubscribeRequest struct {
+	ID     string            `json:"id,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// SubscribeResponse is the data returned from a subscribe action.
+type SubscribeResponse struct {
+	SubscriptionID string `json:"subscription_id"`
+}
+
+// UnsubscribeRequest is the data for an unsubscribe action.
+type UnsubscribeRequest struct {
+	SubscriptionID string `json:"subscription_id"`
+}
+
+// EventFrame is pushed for every session event a subscription receives,
+// with type "event", and once the subscription ends, with type
+// "subscription_end" and the reason.
+type EventFrame struct {
+	Type         string            `json:"type"`
+	Subscription string            `json:"subscription"`
+	Event        string            `json:"event,omitempty"` // "spawned", "exited", "resized", "attached", "detached", "title", "bell" or "idle"
+	ID           string            `json:"id,omitempty"`
+	Time         string            `json:"time,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	ExitCode     *int              `json:"exit_code,omitempty"`
+	Signal       string            `json:"signal,omitempty"`
+	Cols         int               `json:"cols,omitempty"`
+	Rows         int               `json:"rows,omitempty"`
+	ClientID     string            `json:"client_id,omitempty"`
+	Reason       string            `json:"reason,omitempty"`
+	Title        *string           `json:"title,omitempty"`
+	Idle         float64           `json:"idle,omitempty"`
+}
+
+// HistoryRequest is the data for a history action. Limit, if positive,
+// returns only the most recent commands.
+type HistoryRequest struct {
+	ID    string `json:"id"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// HistoryResponse is the data returned from a history action.
+type HistoryResponse struct {
+	ID       string           `json:"id"`
+	Commands []HistoryCommand `json:"commands"`
+	Count    int              `json:"count"`
+}
+
+// HistoryCommand is a command run in a session's shell.
+type HistoryCommand struct {
+	Command    string  `json:"command"`
+	Start      int64   `json:"start"`
+	End        int64   `json:"end,omitempty"`
+	ExitCode   *int    `json:"exit_code,omitempty"`
+	Running    bool    `json:"running"`
+	StartedAt  string  `json:"started_at"`
+	FinishedAt string  `json:"finished_at,omitempty"`
+	Duration   float64 `json:"duration"`
+}
+
+// PsRequest is the data for a ps action.
+type PsRequest struct {
+	ID string `json:"id"`
+}
+
+// PsResponse is the data returned from a ps action. Foreground is the ID of
+// the terminal's foreground process group, or 0 if none of the processes
+// are in it.
+type PsResponse struct {
+	ID         string        `json:"id"`
+	Foreground int           `json:"foreground_pgid"`
+	Processes  []ProcessInfo `json:"processes"`
+}
+
+// ProcessInfo describes a process in a session. CPU is the CPU time used
+// in seconds and CPUPercent that time relative to the process's lifetime,
+// as ps reports it. RSS is in bytes.
+type ProcessInfo struct {
+	PID        int      `json:"pid"`
+	PPID       int      `json:"ppid"`
+	PGID       int      `json:"pgid"`
+	Name       string   `json:"name"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	State      string   `json:"state"`
+	CPU        float64  `json:"cpu"`
+	CPUPercent float64  `json:"cpu_percent"`
+	RSS        int64    `json:"rss"`
+	StartedAt  string   `json:"started_at"`
+	Foreground bool     `json:"foreground"`
 }
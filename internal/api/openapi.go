@@ -0,0 +1,226 @@
+package api
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// openAPI builds an OpenAPI 3 description of the REST routes. Request and
+// response schemas are derived from the message types by reflection, so the
+// description cannot drift from what the server actually accepts.
+func openAPI(routes []restRoute, token bool) map[string]interface{} {
+	g := &schemaGen{schemas: make(map[string]interface{})}
+	paths := make(map[string]interface{})
+
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"summary":     rt.Summary,
+			"operationId": rt.OperationID,
+		}
+
+		var params []interface{}
+		for _, name := range pathParams(rt.Path) {
+			params = append(params, map[string]interface{}{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, q := range rt.Query {
+			params = append(params, map[string]interface{}{
+				"name":        q.Name,
+				"in":          "query",
+				"description": q.Description,
+				"schema":      map[string]interface{}{"type": q.Type},
+			})
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+
+		if rt.Request != nil {
+			schema := g.schema(reflect.TypeOf(rt.Request), pathParams(rt.Path))
+			op["requestBody"] = map[string]interface{}{
+				"required": !rt.BodyOptional,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": schema},
+				},
+			}
+		}
+
+		responses := map[string]interface{}{
+			"default": jsonContent("Error", g.envelope(nil)),
+		}
+		status := "200"
+		if rt.Status != 0 {
+			status = strconv.Itoa(rt.Status)
+		}
+		switch {
+		case rt.ContentType != "":
+			responses[status] = map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					rt.ContentType: map[string]interface{}{
+						"schema": map[string]interface{}{"type": "string", "format": "binary"},
+					},
+				},
+			}
+		case rt.Response != nil:
+			responses[status] = jsonContent("Success", g.envelope(reflect.TypeOf(rt.Response)))
+		default:
+			responses[status] = jsonContent("Success", g.envelope(nil))
+		}
+		op["responses"] = responses
+
+		item, _ := paths[rt.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[rt.Path] = item
+		}
+		item[strings.ToLower(rt.Method)] = op
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "webpty-pty",
+			"description": "Session management API of the webpty PTY daemon.",
+			"version":     "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+		},
+	}
+	if token {
+		components := doc["components"].(map[string]interface{})
+		components["securitySchemes"] = map[string]interface{}{
+			"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+		}
+		doc["security"] = []interface{}{map[string]interface{}{"bearer": []interface{}{}}}
+	}
+	return doc
+}
+
+func jsonContent(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// pathParams returns the names of the {name} segments of a route path.
+func pathParams(path string) []string {
+	var names []string
+	for _, seg := range strings.Split(path, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			names = append(names, strings.Trim(seg, "{}"))
+		}
+	}
+	return names
+}
+
+// schemaGen converts Go types to JSON schemas, collecting named struct types
+// as reusable components.
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+// envelope returns the schema of a Response carrying data of type t, or no
+// data if t is nil.
+func (g *schemaGen) envelope(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{
+		"ok":  map[string]interface{}{"type": "boolean"},
+		"err": map[string]interface{}{"type": "string"},
+	}
+	if t != nil {
+		props["data"] = g.schema(t, nil)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+		"required":   []string{"ok"},
+	}
+}
+
+// schema returns the schema of t. Fields of a top-level struct whose JSON
+// names are in omit are left out; they are taken from the path instead.
+func (g *schemaGen) schema(t reflect.Type, omit []string) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem(), nil)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem(), nil)}
+	case reflect.Struct:
+		if len(omit) == 0 && t.Name() != "" {
+			if _, ok := g.schemas[t.Name()]; !ok {
+				// Reserve the name first so that recursive types terminate.
+				g.schemas[t.Name()] = nil
+				g.schemas[t.Name()] = g.structSchema(t, nil)
+			}
+			return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		}
+		return g.structSchema(t, omit)
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type, omit []string) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if contains(omit, name) {
+			continue
+		}
+		props[name] = g.schema(f.Type, nil)
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
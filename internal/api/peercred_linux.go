@@ -0,0 +1,37 @@
+//go:build linux
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentials returns the credentials of the process on the other end of
+// a UNIX socket connection using SO_PEERCRED.
+func peerCredentials(conn net.Conn) (peerCreds, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return peerCreds{}, fmt.Errorf("not a unix socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return peerCreds{}, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return peerCreds{}, err
+	}
+	if credErr != nil {
+		return peerCreds{}, credErr
+	}
+
+	return peerCreds{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}, nil
+}
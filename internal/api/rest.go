@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+// maxRESTBody bounds the size of REST request bodies.
+const maxRESTBody = 1 << 20
+
+// restRoute describes a REST endpoint. Most endpoints run one of the socket
+// actions with a request built from the path and body, so they behave
+// exactly like the socket protocol.
+type restRoute struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	// Action is the socket action the endpoint runs; path parameters are
+	// merged into the request body under their names.
+	Action string
+	// Request and Response are values of the body and data types, for the
+	// OpenAPI description. BodyOptional allows an empty body.
+	Request      interface{}
+	Response     interface{}
+	BodyOptional bool
+	Status       int
+	// Handler serves endpoints that are not an action, with the content type
+	// of their response.
+	Handler     http.HandlerFunc
+	ContentType string
+	Query       []queryParam
+}
+
+type queryParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+func (h *HTTPServer) restRoutes() []restRoute {
+	return []restRoute{
+		{
+			Method: "POST", Path: "/sessions", OperationID: "spawnSession",
+			Summary: "Spawn a session", Action: "spawn",
+			Request: SpawnRequest{}, Response: SpawnResponse{}, BodyOptional: true, Status: http.StatusCreated,
+		},
+		{
+			Method: "GET", Path: "/sessions", OperationID: "listSessions",
+			Summary: "List sessions", Action: "list",
+			Response: ListResponse{},
+		},
+		{
+			Method: "GET", Path: "/sessions/{id}", OperationID: "getSession",
+			Summary: "Get a session", Action: "get",
+			Response: SessionInfo{},
+		},
+		{
+			Method: "POST", Path: "/sessions/{id}/input", OperationID: "writeInput",
+			Summary: "Type input into a session", Action: "write",
+			Request: WriteRequest{},
+		},
+		{
+			Method: "POST", Path: "/sessions/{id}/resize", OperationID: "resizeSession",
+			Summary: "Resize a session's terminal", Action: "resize",
+			Request: ResizeRequest{},
+		},
+		{
+			Method: "DELETE", Path: "/sessions/{id}", OperationID: "killSession",
+			Summary: "Kill a session", Action: "kill",
+			Request: KillRequest{}, BodyOptional: true,
+		},
+		{
+			Method: "GET", Path: "/sessions/{id}/events", OperationID: "streamOutput",
+			Summary: "Stream a session's output as Server-Sent Events",
+			Handler: h.handleEvents, ContentType: "text/event-stream",
+			Query: []queryParam{
+				{Name: "encoding", Type: "string", Description: "Encoding of output data: base64 (default) or utf8"},
+				{Name: "since", Type: "integer", Description: "Offset to resume from when there is no Last-Event-ID header"},
+			},
+		},
+		{
+			Method: "GET", Path: "/sessions/{id}/log", OperationID: "getLog",
+			Summary: "Download a session's log, including rotated segments",
+			Handler: h.handleLog, ContentType: "application/octet-stream",
+			Query: []queryParam{
+				{Name: "plain", Type: "boolean", Description: "Return the plain text log instead of the raw log"},
+			},
+		},
+	}
+}
+
+// registerREST adds the REST endpoints and their OpenAPI description to the
+// mux.
+func (h *HTTPServer) registerREST() {
+	routes := h.restRoutes()
+	for _, rt := range routes {
+		handler := rt.Handler
+		if handler == nil {
+			handler = h.actionHandler(rt)
+		}
+		if rt.Method != http.MethodGet {
+			handler = h.sameOrigin(handler)
+		}
+		h.mux.HandleFunc(rt.Method+" "+rt.Path, h.authorize(handler))
+	}
+
+	doc, err := json.MarshalIndent(openAPI(routes, h.opts.Token != ""), "", "  ")
+	if err != nil {
+		log.Printf("[PTY] Failed to generate OpenAPI description: %v", err)
+		return
+	}
+	h.mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+}
+
+// sameOrigin refuses requests from browser pages of other origins, which
+// could otherwise change sessions using a token stored in the browser.
+func (h *HTTPServer) sameOrigin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.checkOrigin(r) {
+			writeError(w, pty.NewError(pty.CodePermissionDenied, "origin %s is not allowed", r.Header.Get("Origin")))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// actionHandler serves a route by running its socket action.
+func (h *HTTPServer) actionHandler(rt restRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields := make(map[string]json.RawMessage)
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRESTBody))
+		if err != nil {
+			writeError(w, pty.WrapError(pty.CodeInvalidArgument, err, "failed to read request body"))
+			return
+		}
+		if rt.Request != nil && len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, &fields); err != nil {
+				writeError(w, invalidRequest(rt.Action, err))
+				return
+			}
+		} else if rt.Request != nil && !rt.BodyOptional {
+			writeError(w, pty.NewError(pty.CodeInvalidArgument, "request body is required"))
+			return
+		}
+		for _, name := range pathParams(rt.Path) {
+			value, _ := json.Marshal(r.PathValue(name))
+			fields[name] = value
+		}
+		data, _ := json.Marshal(fields)
+
+		var out bytes.Buffer
+		c := newClientConn(&out)
+		c.creds = requestCreds(r)
+		h.server.dispatch(c, Request{Action: rt.Action, Data: data})
+
+		var resp struct {
+			Ok   bool   `json:"ok"`
+			Code string `json:"code"`
+		}
+		json.Unmarshal(out.Bytes(), &resp)
+		status := http.StatusOK
+		if rt.Status != 0 {
+			status = rt.Status
+		}
+		if !resp.Ok {
+			status = httpStatus(pty.Code(resp.Code))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write(out.Bytes())
+	}
+}
+
+// handleLog streams a session's log file.
+func (h *HTTPServer) handleLog(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !logAuthorized(requestCreds(r).UID, id) {
+		writeError(w, pty.ErrPermissionDenied)
+		return
+	}
+
+	plain := r.URL.Query().Get("plain") == "true"
+	rc, err := pty.OpenLog(id, plain)
+	if err != nil {
+		if os.IsNotExist(err) {
+			writeError(w, pty.NewError(pty.CodeNotFound, "log not found"))
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	defer rc.Close()
+
+	if plain {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	io.Copy(w, rc)
+}
+
+// writeError responds with the error response for err and the HTTP status
+// of its code.
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus(pty.CodeOf(err)))
+	json.NewEncoder(w).Encode(errorResponse(err))
+}
@@ -1,14 +1,24 @@
 package api
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"regexp"
+	"sort"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/PiranhaCodes/webpty-pty/internal/asciicast"
 	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+	"github.com/google/uuid"
 )
 
 // Server handles UNIX socket connections and PTY session management.
@@ -73,153 +83,380 @@ func (s *Server) Stop() {
 	log.Println("[PTY] Server stopped")
 }
 
+// peerCreds identifies the process on the other end of a connection.
+type peerCreds struct {
+	UID int
+	GID int
+	PID int
+}
+
+// clientConn holds the per-connection state shared by the action handlers.
+// Responses and pushed frames are written through send so that they never
+// interleave on the wire.
+type clientConn struct {
+	creds    peerCreds
+	mu       sync.Mutex
+	w        io.Writer
+	encoder  *json.Encoder
+	attached map[string]*pty.Client
+	watches  map[string]*pty.Watch
+	subs     map[string]*pty.Subscription
+
+	// binaryAllowed is set on connections that may switch to binary
+	// framing, and binary once they have. indexes and sessions map session
+	// IDs to the indexes used in binary frames and back.
+	binaryAllowed bool
+	binary        bool
+	indexes       map[string]uint16
+	sessions      map[uint16]string
+}
+
+func newClientConn(w io.Writer) *clientConn {
+	return &clientConn{
+		w:        w,
+		encoder:  json.NewEncoder(w),
+		attached: make(map[string]*pty.Client),
+		watches:  make(map[string]*pty.Watch),
+		subs:     make(map[string]*pty.Subscription),
+		indexes:  make(map[string]uint16),
+		sessions: make(map[uint16]string),
+	}
+}
+
+func (c *clientConn) send(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeLocked(v)
+}
+
+// writeLocked writes a response or frame in the connection's framing. c.mu
+// must be held.
+func (c *clientConn) writeLocked(v interface{}) error {
+	if !c.binary {
+		return c.encoder.Encode(v)
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(c.w, frameJSON, 0, payload)
+}
+
+// sendOutput pushes a chunk of output of the session with the given ID: as
+// an output frame in binary framing, otherwise as an output Frame encoded by
+// enc. It returns the offset just past the output sent so far.
+func (c *clientConn) sendOutput(id string, enc *outputEncoder, out pty.Output) (int64, error) {
+	c.mu.Lock()
+	if c.binary {
+		defer c.mu.Unlock()
+		index, err := c.sessionIndexLocked(id)
+		if err != nil {
+			return out.Offset, err
+		}
+		return out.Offset + int64(len(out.Data)), writeFrame(c.w, frameOutput, index, out.Data)
+	}
+	c.mu.Unlock()
+
+	frame, ok := outputFrame(id, enc, out)
+	if !ok {
+		return frame.Offset, nil
+	}
+	return frame.Offset, c.send(frame)
+}
+
+// binaryIndex returns the index of the session with the given ID if the
+// connection uses binary framing, or 0.
+func (c *clientConn) binaryIndex(id string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.binary {
+		return 0, nil
+	}
+	index, err := c.sessionIndexLocked(id)
+	return int(index), err
+}
+
+// detachAll detaches every session the connection is attached to and
+// removes its pattern watches and event subscriptions.
+func (c *clientConn) detachAll() {
+	c.mu.Lock()
+	clients := make([]*pty.Client, 0, len(c.attached))
+	for _, cl := range c.attached {
+		clients = append(clients, cl)
+	}
+	watches := make([]*pty.Watch, 0, len(c.watches))
+	for _, w := range c.watches {
+		watches = append(watches, w)
+	}
+	subs := make([]*pty.Subscription, 0, len(c.subs))
+	for _, sub := range c.subs {
+		subs = append(subs, sub)
+	}
+	c.mu.Unlock()
+
+	for _, cl := range clients {
+		cl.Detach()
+	}
+	for _, w := range watches {
+		w.Remove()
+	}
+	for _, sub := range subs {
+		sub.Unsubscribe()
+	}
+}
+
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
 
-	decoder := json.NewDecoder(conn)
-	encoder := json.NewEncoder(conn)
+	c := newClientConn(conn)
+	c.binaryAllowed = true
+	defer c.detachAll()
 
-	var req Request
-	if err := decoder.Decode(&req); err != nil {
-		encoder.Encode(Response{Ok: false, Err: "invalid request: " + err.Error()})
+	creds, err := peerCredentials(conn)
+	if err != nil {
+		c.send(errorResponse(pty.WrapError(pty.CodeInternal, err, "failed to read peer credentials")))
 		return
 	}
+	c.creds = creds
+
+	decoder := json.NewDecoder(conn)
+	for {
+		c.mu.Lock()
+		binary := c.binary
+		c.mu.Unlock()
+		if binary {
+			s.serveBinary(c, bufio.NewReader(io.MultiReader(decoder.Buffered(), conn)))
+			return
+		}
+
+		var req Request
+		if err := decoder.Decode(&req); err != nil {
+			if err != io.EOF {
+				c.send(errorResponse(pty.WrapError(pty.CodeInvalidArgument, err, "invalid request")))
+			}
+			return
+		}
+		s.dispatch(c, req)
+	}
+}
+
+// actions maps action names to their handlers. It is filled in by init
+// because hello lists it.
+var actions map[string]func(*Server, *clientConn, json.RawMessage)
+
+func init() {
+	actions = map[string]func(*Server, *clientConn, json.RawMessage){
+		"hello":  (*Server).handleHello,
+		"spawn":  (*Server).handleSpawn,
+		"write":  (*Server).handleWrite,
+		"resize": (*Server).handleResize,
+		"kill":   (*Server).handleKill,
+		"list": func(s *Server, c *clientConn, _ json.RawMessage) {
+			s.handleList(c)
+		},
+		"get":      (*Server).handleGet,
+		"attach":   (*Server).handleAttach,
+		"detach":   (*Server).handleDetach,
+		"snapshot": (*Server).handleSnapshot,
+		"replay":   (*Server).handleReplay,
+		"search":   (*Server).handleSearch,
+		"exec":     (*Server).handleExec,
+		"watch":    (*Server).handleWatch,
+		"unwatch":  (*Server).handleUnwatch,
+		"framing":  (*Server).handleFraming,
+		"history":  (*Server).handleHistory,
+		"ps":       (*Server).handlePs,
 
-	switch req.Action {
-	case "spawn":
-		s.handleSpawn(req.Data, encoder)
-	case "write":
-		s.handleWrite(req.Data, encoder)
-	case "resize":
-		s.handleResize(req.Data, encoder)
-	case "kill":
-		s.handleKill(req.Data, encoder)
-	case "list":
-		s.handleList(encoder)
-	default:
-		encoder.Encode(Response{Ok: false, Err: "unknown action: " + req.Action})
+		"subscribe":   (*Server).handleSubscribe,
+		"unsubscribe": (*Server).handleUnsubscribe,
 	}
 }
 
-func (s *Server) handleSpawn(data json.RawMessage, encoder *json.Encoder) {
+func (s *Server) dispatch(c *clientConn, req Request) {
+	handler, ok := actions[req.Action]
+	if !ok {
+		c.send(errorResponse(&pty.Error{
+			Code:    pty.CodeInvalidArgument,
+			Message: "unknown action: " + req.Action,
+			Details: map[string]interface{}{"action": req.Action},
+		}))
+		return
+	}
+	handler(s, c, req.Data)
+}
+
+func (s *Server) handleSpawn(c *clientConn, data json.RawMessage) {
 	var req SpawnRequest
 	if len(data) > 0 {
 		if err := json.Unmarshal(data, &req); err != nil {
-			encoder.Encode(Response{Ok: false, Err: "invalid spawn request: " + err.Error()})
+			c.send(errorResponse(invalidRequest("spawn", err)))
+			return
+		}
+	}
+
+	if _, ok := req.Labels[""]; ok {
+		c.send(errorResponse(pty.InvalidField("labels", "label names must not be empty")))
+		return
+	}
+
+	var dir string
+	if req.CwdFrom != "" {
+		src, err := pty.DefaultManager.Lookup(req.CwdFrom)
+		if err != nil {
+			c.send(errorResponse(err))
+			return
+		}
+		if !src.Authorized(c.creds.UID) {
+			c.send(errorResponse(pty.ErrPermissionDenied))
+			return
+		}
+		dir = src.Cwd()
+		if dir == "" {
+			c.send(errorResponse(pty.InvalidField("cwd_from", "working directory of session %s is unknown", req.CwdFrom)))
 			return
 		}
 	}
 
-	sess, err := pty.SpawnShell()
+	sess, err := pty.SpawnShell(pty.SpawnOptions{
+		Owner:       c.creds.UID,
+		Cols:        req.Cols,
+		Rows:        req.Rows,
+		Emulator:    req.Emulator,
+		Record:      req.Record,
+		RecordInput: req.RecordInput,
+		Labels:      req.Labels,
+		Dir:         dir,
+		User:        req.User,
+
+		ShellIntegration: req.ShellIntegration,
+	})
 	if err != nil {
-		encoder.Encode(Response{Ok: false, Err: err.Error()})
+		c.send(errorResponse(err))
 		return
 	}
 
-	encoder.Encode(Response{
+	c.send(Response{
 		Ok:   true,
-		Data: SpawnResponse{ID: sess.ID},
+		Data: SpawnResponse{ID: sess.ID, ShellIntegration: sess.ShellIntegration},
 	})
 }
 
-func (s *Server) handleWrite(data json.RawMessage, encoder *json.Encoder) {
+func (s *Server) handleWrite(c *clientConn, data json.RawMessage) {
 	var req WriteRequest
 	if err := json.Unmarshal(data, &req); err != nil {
-		encoder.Encode(Response{Ok: false, Err: "invalid write request: " + err.Error()})
+		c.send(errorResponse(invalidRequest("write", err)))
 		return
 	}
 
 	if req.ID == "" {
-		encoder.Encode(Response{Ok: false, Err: "session ID is required"})
+		c.send(errorResponse(errSessionIDRequired))
 		return
 	}
 
-	sess := pty.DefaultManager.Get(req.ID)
-	if sess == nil {
-		encoder.Encode(Response{Ok: false, Err: "session not found"})
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	input, err := decodeData(req.Data, req.Encoding)
+	if err != nil {
+		c.send(errorResponse(err))
 		return
 	}
 
-	_, err := sess.Write([]byte(req.Data))
+	_, err = sess.Write(input)
 	if err != nil {
-		encoder.Encode(Response{Ok: false, Err: err.Error()})
+		c.send(errorResponse(err))
 		return
 	}
 
-	encoder.Encode(Response{Ok: true})
+	c.send(Response{Ok: true})
 }
 
-func (s *Server) handleResize(data json.RawMessage, encoder *json.Encoder) {
+func (s *Server) handleResize(c *clientConn, data json.RawMessage) {
 	var req ResizeRequest
 	if err := json.Unmarshal(data, &req); err != nil {
-		encoder.Encode(Response{Ok: false, Err: "invalid resize request: " + err.Error()})
+		c.send(errorResponse(invalidRequest("resize", err)))
 		return
 	}
 
 	if req.ID == "" {
-		encoder.Encode(Response{Ok: false, Err: "session ID is required"})
+		c.send(errorResponse(errSessionIDRequired))
 		return
 	}
 
 	if req.Cols <= 0 || req.Rows <= 0 {
-		encoder.Encode(Response{Ok: false, Err: "cols and rows must be positive"})
+		c.send(errorResponse(pty.NewError(pty.CodeInvalidArgument, "cols and rows must be positive")))
 		return
 	}
 
-	sess := pty.DefaultManager.Get(req.ID)
-	if sess == nil {
-		encoder.Encode(Response{Ok: false, Err: "session not found"})
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
 		return
 	}
 
-	err := sess.Resize(req.Cols, req.Rows)
+	err = sess.Resize(req.Cols, req.Rows)
 	if err != nil {
-		encoder.Encode(Response{Ok: false, Err: err.Error()})
+		c.send(errorResponse(err))
 		return
 	}
 
-	encoder.Encode(Response{Ok: true})
+	c.send(Response{Ok: true})
 }
 
-func (s *Server) handleKill(data json.RawMessage, encoder *json.Encoder) {
+func (s *Server) handleKill(c *clientConn, data json.RawMessage) {
 	var req KillRequest
 	if err := json.Unmarshal(data, &req); err != nil {
-		encoder.Encode(Response{Ok: false, Err: "invalid kill request: " + err.Error()})
+		c.send(errorResponse(invalidRequest("kill", err)))
 		return
 	}
 
 	if req.ID == "" {
-		encoder.Encode(Response{Ok: false, Err: "session ID is required"})
+		c.send(errorResponse(errSessionIDRequired))
 		return
 	}
 
-	sess := pty.DefaultManager.Get(req.ID)
-	if sess == nil {
-		encoder.Encode(Response{Ok: false, Err: "session not found"})
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
 		return
 	}
 
+	if !req.Force {
+		if err := sess.CheckIdle(); err != nil {
+			c.send(errorResponse(err))
+			return
+		}
+	}
+
 	pty.CleanupSession(sess)
-	encoder.Encode(Response{Ok: true})
+	c.send(Response{Ok: true})
 }
 
-func (s *Server) handleList(encoder *json.Encoder) {
+func (s *Server) handleList(c *clientConn) {
 	sessions := pty.DefaultManager.List()
 	infos := make([]SessionInfo, 0, len(sessions))
 	for _, sess := range sessions {
-		status := "active"
-		if sess.Cmd != nil && sess.Cmd.Process != nil {
-			if err := sess.Cmd.Process.Signal(syscall.Signal(0)); err != nil {
-				status = "exiting"
-			}
+		if sess.Authorized(c.creds.UID) {
+			infos = append(infos, sessionInfo(sess))
 		}
-		infos = append(infos, SessionInfo{
-			ID:     sess.ID,
-			Status: status,
-		})
 	}
 
-	encoder.Encode(Response{
+	c.send(Response{
 		Ok: true,
 		Data: ListResponse{
 			Sessions: infos,
@@ -227,3 +464,893 @@ func (s *Server) handleList(encoder *json.Encoder) {
 		},
 	})
 }
+
+func (s *Server) handleGet(c *clientConn, data json.RawMessage) {
+	var req GetRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("get", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	c.send(Response{Ok: true, Data: sessionInfo(sess)})
+}
+
+func (s *Server) handleAttach(c *clientConn, data json.RawMessage) {
+	var req AttachRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("attach", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	encoding, err := checkEncoding(req.Encoding)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	c.mu.Lock()
+	_, already := c.attached[req.ID]
+	c.mu.Unlock()
+	if already {
+		c.send(errorResponse(pty.NewError(pty.CodeInvalidArgument, "already attached")))
+		return
+	}
+
+	since := int64(-1)
+	if req.Since != nil {
+		since = *req.Since
+	}
+
+	client, restore, offset, err := sess.Attach(c.creds.UID, c.creds.PID, since)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	c.mu.Lock()
+	c.attached[req.ID] = client
+	c.mu.Unlock()
+
+	index, err := c.binaryIndex(req.ID)
+	if err != nil {
+		client.Detach()
+		c.send(errorResponse(err))
+		return
+	}
+
+	c.send(Response{
+		Ok: true,
+		Data: AttachResponse{
+			ID:       req.ID,
+			ClientID: client.ID,
+			Offset:   offset,
+			Index:    index,
+		},
+	})
+
+	go s.streamOutput(c, req.ID, client, restore, offset, encoding)
+}
+
+// streamOutput pushes the restore data and then live output of an attached
+// session to the connection until the client is detached.
+func (s *Server) streamOutput(c *clientConn, id string, client *pty.Client, restore []byte, offset int64, encoding string) {
+	defer func() {
+		c.mu.Lock()
+		if c.attached[id] == client {
+			delete(c.attached, id)
+		}
+		c.mu.Unlock()
+	}()
+
+	enc := &outputEncoder{encoding: encoding}
+	if len(restore) > 0 {
+		var err error
+		offset, err = c.sendOutput(id, enc, pty.Output{Offset: offset - int64(len(restore)), Data: restore})
+		if err != nil {
+			client.Detach()
+			return
+		}
+	}
+
+	for {
+		select {
+		case out := <-client.Output():
+			var err error
+			offset, err = c.sendOutput(id, enc, out)
+			if err != nil {
+				client.Detach()
+				return
+			}
+		case <-client.Done():
+			// Flush whatever was queued before the client was detached.
+		drain:
+			for {
+				select {
+				case out := <-client.Output():
+					offset, _ = c.sendOutput(id, enc, out)
+				default:
+					break drain
+				}
+			}
+			frame := Frame{Type: "detached", ID: id, Offset: offset, Reason: client.Err().Error()}
+			if client.Err() == pty.ErrSessionClosed {
+				frame = Frame{Type: "exit", ID: id, Offset: offset}
+			}
+			c.send(frame)
+			return
+		}
+	}
+}
+
+func (s *Server) handleDetach(c *clientConn, data json.RawMessage) {
+	var req DetachRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("detach", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	clientID := req.ClientID
+	if clientID == "" {
+		c.mu.Lock()
+		client := c.attached[req.ID]
+		c.mu.Unlock()
+		if client == nil {
+			c.send(errorResponse(pty.NewError(pty.CodeInvalidArgument, "not attached")))
+			return
+		}
+		clientID = client.ID
+	} else if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	if !sess.Detach(clientID) {
+		c.send(errorResponse(pty.NewError(pty.CodeNotFound, "client not found")))
+		return
+	}
+
+	c.send(Response{Ok: true})
+}
+
+func (s *Server) handleSnapshot(c *clientConn, data json.RawMessage) {
+	var req SnapshotRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("snapshot", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	if req.Format == "" {
+		req.Format = "text"
+	}
+	if req.Format != "text" && req.Format != "cells" && req.Format != "ansi" {
+		c.send(errorResponse(pty.InvalidField("format", "format must be text, cells or ansi")))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	snap, err := sess.Snapshot()
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	resp := SnapshotResponse{
+		ID:            req.ID,
+		Format:        req.Format,
+		Cols:          snap.Cols,
+		Rows:          snap.Rows,
+		CursorX:       snap.CursorX,
+		CursorY:       snap.CursorY,
+		CursorVisible: snap.CursorVisible,
+		AltScreen:     snap.AltScreen,
+		ScrollTop:     snap.ScrollTop,
+		ScrollBottom:  snap.ScrollBottom,
+	}
+	switch req.Format {
+	case "text":
+		resp.Text = snap.Text()
+	case "cells":
+		resp.Cells = snap.Cells()
+	case "ansi":
+		resp.ANSI = snap.ANSI()
+	}
+
+	c.send(Response{Ok: true, Data: resp})
+}
+
+// handleReplay streams a stored recording to the connection with its
+// original timing. The connection processes no other requests until the
+// replay has finished.
+func (s *Server) handleReplay(c *clientConn, data json.RawMessage) {
+	var req ReplayRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("replay", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	if req.Speed < 0 || req.Seek < 0 || req.MaxIdle < 0 {
+		c.send(errorResponse(pty.NewError(pty.CodeInvalidArgument, "speed, seek and max_idle must not be negative")))
+		return
+	}
+
+	encoding, err := checkEncoding(req.Encoding)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	if !logAuthorized(c.creds.UID, req.ID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	path, err := pty.RecordingPath(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.send(errorResponse(pty.NewError(pty.CodeNotFound, "recording not found")))
+			return
+		}
+		c.send(errorResponse(err))
+		return
+	}
+	defer f.Close()
+
+	reader, err := asciicast.NewReader(f)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	index, err := c.binaryIndex(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	c.send(Response{
+		Ok: true,
+		Data: ReplayResponse{
+			ID:    req.ID,
+			Cols:  reader.Header.Width,
+			Rows:  reader.Header.Height,
+			Index: index,
+		},
+	})
+
+	var offset int64
+	enc := &outputEncoder{encoding: encoding}
+	opts := asciicast.PlayOptions{
+		Speed:   req.Speed,
+		Seek:    time.Duration(req.Seek * float64(time.Second)),
+		MaxIdle: time.Duration(req.MaxIdle * float64(time.Second)),
+	}
+	err = asciicast.Play(context.Background(), reader, opts, func(ev asciicast.Event) error {
+		if ev.Code == asciicast.EventResize {
+			cols, rows, err := ev.Size()
+			if err != nil {
+				return err
+			}
+			return c.send(Frame{Type: "resize", ID: req.ID, Offset: offset, Cols: cols, Rows: rows})
+		}
+		out := pty.Output{Offset: offset, Data: []byte(ev.Data)}
+		offset += int64(len(out.Data))
+		_, err := c.sendOutput(req.ID, enc, out)
+		return err
+	})
+	if err != nil {
+		log.Printf("[PTY] Replay of %s stopped: %v", req.ID, err)
+		c.send(Frame{Type: "detached", ID: req.ID, Offset: offset, Reason: err.Error()})
+		return
+	}
+
+	c.send(Frame{Type: "exit", ID: req.ID, Offset: offset})
+}
+
+func (s *Server) handleHistory(c *clientConn, data json.RawMessage) {
+	var req HistoryRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("history", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	if req.Limit < 0 {
+		c.send(errorResponse(pty.InvalidField("limit", "limit must not be negative")))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	history := sess.History()
+	if req.Limit > 0 && len(history) > req.Limit {
+		history = history[len(history)-req.Limit:]
+	}
+
+	resp := HistoryResponse{ID: req.ID, Commands: make([]HistoryCommand, 0, len(history))}
+	for _, cmd := range history {
+		hc := HistoryCommand{
+			Command:   cmd.Command,
+			Start:     cmd.Start,
+			ExitCode:  cmd.ExitCode,
+			Running:   cmd.Running(),
+			StartedAt: cmd.StartedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if cmd.Running() {
+			hc.Duration = time.Since(cmd.StartedAt).Seconds()
+		} else {
+			hc.End = cmd.End
+			hc.FinishedAt = cmd.FinishedAt.UTC().Format(time.RFC3339Nano)
+			hc.Duration = cmd.FinishedAt.Sub(cmd.StartedAt).Seconds()
+		}
+		resp.Commands = append(resp.Commands, hc)
+	}
+	resp.Count = len(resp.Commands)
+
+	c.send(Response{Ok: true, Data: resp})
+}
+
+func (s *Server) handlePs(c *clientConn, data json.RawMessage) {
+	var req PsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("ps", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	procs, err := sess.Processes()
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	now := time.Now()
+	resp := PsResponse{ID: req.ID, Processes: make([]ProcessInfo, 0, len(procs))}
+	for _, p := range procs {
+		info := ProcessInfo{
+			PID:        p.PID,
+			PPID:       p.PPID,
+			PGID:       p.PGID,
+			Name:       p.Name,
+			Command:    p.CommandLine(),
+			Args:       p.Args,
+			State:      p.State,
+			CPU:        p.CPUTime.Seconds(),
+			RSS:        p.RSS,
+			StartedAt:  p.StartedAt.UTC().Format(time.RFC3339),
+			Foreground: p.Foreground,
+		}
+		if info.Args == nil {
+			info.Args = []string{}
+		}
+		if elapsed := now.Sub(p.StartedAt); elapsed > 0 {
+			info.CPUPercent = 100 * p.CPUTime.Seconds() / elapsed.Seconds()
+		}
+		if p.Foreground && resp.Foreground == 0 {
+			resp.Foreground = p.PGID
+		}
+		resp.Processes = append(resp.Processes, info)
+	}
+
+	c.send(Response{Ok: true, Data: resp})
+}
+
+// Defaults and bounds for exec.
+const (
+	defaultExecTimeout   = 60 * time.Second
+	defaultExecMaxOutput = 1024 * 1024
+	maxExecMaxOutput     = 16 * 1024 * 1024
+)
+
+// handleExec runs a command in a PTY and responds with its output and exit
+// status. The connection processes no other requests until it has finished.
+func (s *Server) handleExec(c *clientConn, data json.RawMessage) {
+	var req ExecRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("exec", err)))
+		return
+	}
+
+	if len(req.Command) == 0 || req.Command[0] == "" {
+		c.send(errorResponse(pty.ErrNoCommand))
+		return
+	}
+
+	if req.Timeout < 0 || req.MaxOutput < 0 {
+		c.send(errorResponse(pty.NewError(pty.CodeInvalidArgument, "timeout and max_output must not be negative")))
+		return
+	}
+
+	timeout := defaultExecTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout * float64(time.Second))
+	}
+	maxOutput := req.MaxOutput
+	if maxOutput == 0 {
+		maxOutput = defaultExecMaxOutput
+	}
+	if maxOutput > maxExecMaxOutput {
+		maxOutput = maxExecMaxOutput
+	}
+
+	res, err := pty.Exec(pty.ExecOptions{
+		Owner:     c.creds.UID,
+		Command:   req.Command,
+		Cols:      req.Cols,
+		Rows:      req.Rows,
+		Stdin:     []byte(req.Stdin),
+		EOF:       req.EOF,
+		Timeout:   timeout,
+		MaxOutput: maxOutput,
+	})
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	output := string(res.Output)
+	if req.Strip {
+		output = vt.Strip(res.Output)
+	}
+
+	c.send(Response{
+		Ok: true,
+		Data: ExecResponse{
+			ID:        res.ID,
+			Output:    output,
+			Truncated: res.Truncated,
+			ExitCode:  res.ExitCode,
+			Signal:    res.Signal,
+			TimedOut:  res.TimedOut,
+			Duration:  res.Duration.Seconds(),
+		},
+	})
+}
+
+func (s *Server) handleWatch(c *clientConn, data json.RawMessage) {
+	var req WatchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("watch", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	if req.Pattern == "" {
+		c.send(errorResponse(pty.InvalidField("pattern", "pattern is required")))
+		return
+	}
+
+	if req.Timeout < 0 {
+		c.send(errorResponse(pty.InvalidField("timeout", "timeout must not be negative")))
+		return
+	}
+
+	re, err := regexp.Compile(req.Pattern)
+	if err != nil {
+		c.send(errorResponse(pty.WrapError(pty.CodeInvalidArgument, err, "invalid regex")))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	opts := pty.WatchOptions{
+		Pattern:  re,
+		Response: []byte(req.Response),
+		Repeat:   req.Repeat,
+		Timeout:  time.Duration(req.Timeout * float64(time.Second)),
+		Since:    -1,
+	}
+	if req.Since != nil {
+		opts.Since = *req.Since
+	}
+
+	// Hold the connection lock so that matches found while registering are
+	// not pushed before the response.
+	c.mu.Lock()
+	w, err := sess.Watch(c.creds.UID, opts)
+	if err != nil {
+		c.mu.Unlock()
+		c.send(errorResponse(err))
+		return
+	}
+	c.watches[w.ID] = w
+	c.writeLocked(Response{
+		Ok:   true,
+		Data: WatchResponse{ID: req.ID, WatchID: w.ID},
+	})
+	c.mu.Unlock()
+
+	go s.streamMatches(c, req.ID, w)
+}
+
+// streamMatches pushes the matches of a watch to the connection until the
+// watch ends.
+func (s *Server) streamMatches(c *clientConn, id string, w *pty.Watch) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.watches, w.ID)
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case m := <-w.Matches():
+			if err := c.send(matchFrame(id, w.ID, m)); err != nil {
+				w.Remove()
+				return
+			}
+		case <-w.Done():
+		drain:
+			for {
+				select {
+				case m := <-w.Matches():
+					c.send(matchFrame(id, w.ID, m))
+				default:
+					break drain
+				}
+			}
+			c.send(Frame{Type: "watch_end", ID: id, Watch: w.ID, Reason: w.Err().Error()})
+			return
+		}
+	}
+}
+
+func matchFrame(id, watchID string, m pty.WatchMatch) Frame {
+	return Frame{Type: "match", ID: id, Offset: m.Offset, Watch: watchID, Data: m.Text, Groups: m.Groups}
+}
+
+func (s *Server) handleUnwatch(c *clientConn, data json.RawMessage) {
+	var req UnwatchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("unwatch", err)))
+		return
+	}
+
+	if req.ID == "" {
+		c.send(errorResponse(errSessionIDRequired))
+		return
+	}
+
+	if req.WatchID == "" {
+		c.send(errorResponse(pty.InvalidField("watch_id", "watch ID is required")))
+		return
+	}
+
+	sess, err := pty.DefaultManager.Lookup(req.ID)
+	if err != nil {
+		c.send(errorResponse(err))
+		return
+	}
+
+	if !sess.Authorized(c.creds.UID) {
+		c.send(errorResponse(pty.ErrPermissionDenied))
+		return
+	}
+
+	if !sess.Unwatch(req.WatchID) {
+		c.send(errorResponse(pty.NewError(pty.CodeNotFound, "watch not found")))
+		return
+	}
+
+	c.send(Response{Ok: true})
+}
+
+// Defaults and bounds for the number of search matches returned.
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// handleSearch searches the scrollback and logs of one session, or of all
+// sessions of a user, for lines matching a query.
+func (s *Server) handleSearch(c *clientConn, data json.RawMessage) {
+	var req SearchRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("search", err)))
+		return
+	}
+
+	if req.Query == "" {
+		c.send(errorResponse(pty.InvalidField("query", "query is required")))
+		return
+	}
+
+	pattern := req.Query
+	if !req.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if req.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.send(errorResponse(pty.WrapError(pty.CodeInvalidArgument, err, "invalid regex")))
+		return
+	}
+
+	scrollback, logs := len(req.Sources) == 0, len(req.Sources) == 0
+	for _, source := range req.Sources {
+		switch source {
+		case pty.SourceScrollback:
+			scrollback = true
+		case pty.SourceLog:
+			logs = true
+		default:
+			c.send(errorResponse(pty.InvalidField("sources", "sources must be scrollback or log")))
+			return
+		}
+	}
+
+	if req.Limit < 0 {
+		c.send(errorResponse(pty.InvalidField("limit", "limit must not be negative")))
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	var ids []string
+	if req.ID != "" {
+		if _, err := uuid.Parse(req.ID); err != nil {
+			c.send(errorResponse(pty.ErrSessionNotFound))
+			return
+		}
+		if !logAuthorized(c.creds.UID, req.ID) {
+			c.send(errorResponse(pty.ErrPermissionDenied))
+			return
+		}
+		ids = []string{req.ID}
+	} else {
+		uid := c.creds.UID
+		if req.UID != nil {
+			uid = *req.UID
+		}
+		if c.creds.UID != 0 && uid != c.creds.UID {
+			c.send(errorResponse(pty.ErrPermissionDenied))
+			return
+		}
+		ids, err = userSessions(uid)
+		if err != nil {
+			c.send(errorResponse(err))
+			return
+		}
+	}
+
+	// Fetch one match more than requested to detect truncation.
+	var matches []pty.Match
+	found := false
+	for _, id := range ids {
+		want := limit + 1 - len(matches)
+		if want <= 0 {
+			break
+		}
+		if logs {
+			m, err := pty.SearchLogs(id, re, want)
+			if err != nil {
+				log.Printf("[PTY] Search of logs of %s failed: %v", id, err)
+			}
+			matches = append(matches, m...)
+			want -= len(m)
+		}
+		if sess := pty.DefaultManager.Get(id); sess != nil {
+			found = true
+			if scrollback && want > 0 {
+				matches = append(matches, sess.SearchScrollback(re, want)...)
+			}
+		}
+	}
+	if req.ID != "" && !found && !hasLogs(req.ID) {
+		c.send(errorResponse(pty.ErrSessionNotFound))
+		return
+	}
+
+	resp := SearchResponse{Matches: make([]SearchMatch, 0, len(matches))}
+	if len(matches) > limit {
+		matches = matches[:limit]
+		resp.Truncated = true
+	}
+	for _, m := range matches {
+		match := SearchMatch{ID: m.ID, Source: m.Source, Offset: m.Offset, Line: m.Line}
+		if !m.Time.IsZero() {
+			match.Time = m.Time.UTC().Format(time.RFC3339Nano)
+		}
+		resp.Matches = append(resp.Matches, match)
+	}
+	resp.Count = len(resp.Matches)
+
+	c.send(Response{Ok: true, Data: resp})
+}
+
+// logAuthorized reports whether the user with the given UID may read the
+// logs and recording of a session. Root and the session's owner may, even
+// after the session has ended.
+func logAuthorized(uid int, id string) bool {
+	return uid == 0 || uid == pty.LogOwner(id)
+}
+
+// userSessions returns the IDs of the running and ended sessions owned by
+// the user with the given UID, sorted.
+func userSessions(uid int) ([]string, error) {
+	logged, err := pty.LoggedSessions()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var ids []string
+	for _, sess := range pty.DefaultManager.List() {
+		seen[sess.ID] = true
+		if sess.Owner == uid {
+			ids = append(ids, sess.ID)
+		}
+	}
+	for _, id := range logged {
+		if !seen[id] && pty.LogOwner(id) == uid {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// hasLogs reports whether any files of the session are in the log directory.
+func hasLogs(id string) bool {
+	logged, _ := pty.LoggedSessions()
+	for _, l := range logged {
+		if l == id {
+			return true
+		}
+	}
+	return false
+}
+
+// outputFrame encodes out as an output frame. It reports false if there is
+// nothing to send yet.
+func outputFrame(id string, enc *outputEncoder, out pty.Output) (Frame, bool) {
+	data, offset := enc.encode(out.Data, out.Offset+int64(len(out.Data)))
+	return Frame{Type: "output", ID: id, Offset: offset, Data: data, Encoding: enc.encoding}, data != ""
+}
+
+func sessionInfo(sess *pty.Session) SessionInfo {
+	status := "active"
+	if sess.Cmd != nil && sess.Cmd.Process != nil {
+		if err := sess.Cmd.Process.Signal(syscall.Signal(0)); err != nil {
+			status = "exiting"
+		}
+	}
+
+	clients := sess.Clients()
+	info := SessionInfo{
+		ID:      sess.ID,
+		Status:  status,
+		State:   string(sess.State()),
+		Owner:   sess.Owner,
+		User:    sess.User,
+		Labels:  sess.Labels,
+		Title:   sess.Title(),
+		Cwd:     sess.Cwd(),
+		Clients: make([]ClientInfo, 0, len(clients)),
+	}
+	if t := sess.DetachedAt(); !t.IsZero() {
+		info.DetachedAt = t.Format(time.RFC3339)
+	}
+	for _, cl := range clients {
+		info.Clients = append(info.Clients, ClientInfo{
+			ID:         cl.ID,
+			UID:        cl.UID,
+			PID:        cl.PID,
+			AttachedAt: cl.AttachedAt.Format(time.RFC3339),
+		})
+	}
+	if u, ok := sess.Usage(); ok {
+		info.Usage = &UsageInfo{
+			Memory:     u.Memory,
+			MemoryPeak: u.MemoryPeak,
+			Pids:       u.Pids,
+			CPU:        u.CPU.Seconds(),
+			OOMKills:   u.OOMKills,
+		}
+	}
+	return info
+}
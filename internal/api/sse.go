@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+// handleEvents streams a session's output as Server-Sent Events. Every
+// output event carries the offset just past its data as its ID, so a client
+// that reconnects with Last-Event-ID resumes where it left off, as long as
+// that output is still in the scrollback. The since query parameter does the
+// same for the first connection; without either, the client starts with the
+// restore data of attach.
+//
+// The encoding query parameter selects how the data is sent: base64
+// (default) sends the raw bytes, and utf8 sends a JSON string, never
+// splitting a character across events.
+func (h *HTTPServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	creds := requestCreds(r)
+	id := r.PathValue("id")
+
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = EncodingBase64
+	}
+	if _, err := checkEncoding(encoding); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	since := int64(-1)
+	resume := r.Header.Get("Last-Event-ID")
+	if resume == "" {
+		resume = r.URL.Query().Get("since")
+	}
+	if resume != "" {
+		n, err := strconv.ParseInt(resume, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, pty.InvalidField("since", "invalid since: %s", resume))
+			return
+		}
+		since = n
+	}
+
+	sess, err := pty.DefaultManager.Lookup(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	client, restore, offset, err := sess.Attach(creds.UID, creds.PID, since)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer client.Detach()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	e := &eventWriter{w: w, enc: outputEncoder{encoding: encoding}}
+	if err := e.output(restore, offset); err != nil {
+		return
+	}
+	rc.Flush()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case out := <-client.Output():
+			if err := e.output(out.Data, out.Offset+int64(len(out.Data))); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-ticker.C:
+			// A comment line keeps proxies from timing out idle streams.
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		case <-client.Done():
+		drain:
+			for {
+				select {
+				case out := <-client.Output():
+					e.output(out.Data, out.Offset+int64(len(out.Data)))
+				default:
+					break drain
+				}
+			}
+			reason, _ := json.Marshal(client.Err().Error())
+			fmt.Fprintf(w, "event: end\ndata: {\"reason\":%s}\n\n", reason)
+			rc.Flush()
+			return
+		}
+	}
+}
+
+// eventWriter writes output events.
+type eventWriter struct {
+	w   http.ResponseWriter
+	enc outputEncoder
+}
+
+// output writes data, which ends at offset, as an output event.
+func (e *eventWriter) output(data []byte, offset int64) error {
+	text, offset := e.enc.encode(data, offset)
+	if text == "" {
+		return nil
+	}
+	payload := []byte(text)
+	if e.enc.encoding == EncodingUTF8 {
+		payload, _ = json.Marshal(text)
+	}
+	_, err := fmt.Fprintf(e.w, "event: output\nid: %d\ndata: %s\n\n", offset, payload)
+	return err
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+func (s *Server) handleSubscribe(c *clientConn, data json.RawMessage) {
+	var req SubscribeRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(errorResponse(invalidRequest("subscribe", err)))
+			return
+		}
+	}
+
+	if req.ID != "" {
+		sess, err := pty.DefaultManager.Lookup(req.ID)
+		if err != nil {
+			c.send(errorResponse(err))
+			return
+		}
+		if !sess.Authorized(c.creds.UID) {
+			c.send(errorResponse(pty.ErrPermissionDenied))
+			return
+		}
+	}
+
+	sub := pty.DefaultEventBus.Subscribe(pty.EventFilter{
+		UID:    c.creds.UID,
+		ID:     req.ID,
+		Labels: req.Labels,
+	})
+	c.mu.Lock()
+	c.subs[sub.ID] = sub
+	c.mu.Unlock()
+
+	c.send(Response{
+		Ok:   true,
+		Data: SubscribeResponse{SubscriptionID: sub.ID},
+	})
+
+	go s.streamEvents(c, sub)
+}
+
+// streamEvents pushes the events of a subscription to the connection until
+// the subscription ends.
+func (s *Server) streamEvents(c *clientConn, sub *pty.Subscription) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.subs, sub.ID)
+		c.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case ev := <-sub.Events():
+			if err := c.send(eventFrame(sub.ID, ev)); err != nil {
+				sub.Unsubscribe()
+				return
+			}
+		case <-sub.Done():
+		drain:
+			for {
+				select {
+				case ev := <-sub.Events():
+					c.send(eventFrame(sub.ID, ev))
+				default:
+					break drain
+				}
+			}
+			c.send(EventFrame{Type: "subscription_end", Subscription: sub.ID, Reason: sub.Err().Error()})
+			return
+		}
+	}
+}
+
+func eventFrame(subID string, ev pty.Event) EventFrame {
+	frame := EventFrame{
+		Type:         "event",
+		Subscription: subID,
+		Event:        string(ev.Type),
+		ID:           ev.ID,
+		Time:         ev.Time.UTC().Format(time.RFC3339Nano),
+		Labels:       ev.Labels,
+	}
+	switch ev.Type {
+	case pty.EventSpawned, pty.EventResized:
+		frame.Cols, frame.Rows = ev.Cols, ev.Rows
+	case pty.EventExited:
+		code := ev.ExitCode
+		frame.ExitCode = &code
+		frame.Signal = ev.Signal
+	case pty.EventAttached:
+		frame.ClientID = ev.ClientID
+	case pty.EventDetached:
+		frame.ClientID, frame.Reason = ev.ClientID, ev.Reason
+	case pty.EventTitle:
+		title := ev.Title
+		frame.Title = &title
+	case pty.EventIdle:
+		frame.Idle = ev.Idle.Seconds()
+	}
+	return frame
+}
+
+func (s *Server) handleUnsubscribe(c *clientConn, data json.RawMessage) {
+	var req UnsubscribeRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.send(errorResponse(invalidRequest("unsubscribe", err)))
+		return
+	}
+
+	if req.SubscriptionID == "" {
+		c.send(errorResponse(pty.InvalidField("subscription_id", "subscription ID is required")))
+		return
+	}
+
+	c.mu.Lock()
+	sub := c.subs[req.SubscriptionID]
+	c.mu.Unlock()
+	if sub == nil {
+		c.send(errorResponse(pty.NewError(pty.CodeNotFound, "subscription not found")))
+		return
+	}
+
+	sub.Unsubscribe()
+	c.send(Response{Ok: true})
+}
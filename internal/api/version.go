@@ -0,0 +1,141 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+// Protocol version spoken by the server. The minor version increases with
+// every backwards-compatible addition; the major version only changes when
+// existing clients would break.
+const (
+	ProtocolMajor = 1
+	ProtocolMinor = 9
+)
+
+// Version is the server version. Release builds set it with
+//
+//	-ldflags "-X github.com/PiranhaCodes/webpty-pty/internal/api.Version=v1.2.3"
+//
+// Otherwise the module version recorded at build time is reported.
+var Version string
+
+// Capabilities that are not tied to a single action.
+const (
+	// CapEncoding is the encoding field of write, attach and replay.
+	CapEncoding = "encoding"
+	// CapBinaryFraming is the binary mode of the framing action.
+	CapBinaryFraming = "binary_framing"
+	// CapErrorCodes is the code and details of failed responses.
+	CapErrorCodes = "error_codes"
+)
+
+// ProtocolVersion returns the protocol version as "major.minor".
+func ProtocolVersion() string {
+	return fmt.Sprintf("%d.%d", ProtocolMajor, ProtocolMinor)
+}
+
+// serverVersion returns Version, or the module version of the build.
+func serverVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// buildInfo describes the binary from the information the Go toolchain
+// embeds in it.
+func buildInfo() BuildInfo {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{}
+	}
+	b := BuildInfo{GoVersion: info.GoVersion}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			b.Revision = setting.Value
+		case "vcs.time":
+			b.Time = setting.Value
+		case "vcs.modified":
+			b.Modified = setting.Value == "true"
+		}
+	}
+	return b
+}
+
+// parseProtocolVersion parses "major" or "major.minor".
+func parseProtocolVersion(v string) (int, int, error) {
+	majorText, minorText, hasMinor := strings.Cut(v, ".")
+	major, err := strconv.Atoi(majorText)
+	if err != nil || major < 0 {
+		return 0, 0, pty.InvalidField("protocol_version", "invalid protocol version: %q", v)
+	}
+	minor := 0
+	if hasMinor {
+		minor, err = strconv.Atoi(minorText)
+		if err != nil || minor < 0 {
+			return 0, 0, pty.InvalidField("protocol_version", "invalid protocol version: %q", v)
+		}
+	}
+	return major, minor, nil
+}
+
+func (s *Server) handleHello(c *clientConn, data json.RawMessage) {
+	var req HelloRequest
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.send(errorResponse(invalidRequest("hello", err)))
+			return
+		}
+	}
+
+	if req.ProtocolVersion != "" {
+		major, _, err := parseProtocolVersion(req.ProtocolVersion)
+		if err != nil {
+			c.send(errorResponse(err))
+			return
+		}
+		// A newer minor version is fine: the client sees ours and can
+		// avoid what the server does not support yet.
+		if major != ProtocolMajor {
+			c.send(errorResponse(&pty.Error{
+				Code:    pty.CodeInvalidArgument,
+				Message: fmt.Sprintf("unsupported protocol version %s: server speaks %s", req.ProtocolVersion, ProtocolVersion()),
+				Details: map[string]interface{}{"protocol_version": ProtocolVersion()},
+			}))
+			return
+		}
+	}
+
+	names := make([]string, 0, len(actions))
+	for name := range actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	capabilities := []string{CapEncoding, CapErrorCodes}
+	if c.binaryAllowed {
+		capabilities = append(capabilities, CapBinaryFraming)
+	}
+
+	c.send(Response{
+		Ok: true,
+		Data: HelloResponse{
+			ProtocolVersion: ProtocolVersion(),
+			ServerVersion:   serverVersion(),
+			Build:           buildInfo(),
+			Actions:         names,
+			Capabilities:    capabilities,
+		},
+	})
+}
@@ -0,0 +1,3 @@
+// Package asciicast writes and reads terminal session recordings in the
+// asciicast v2 format used by asciinema and compatible players.
+package asciicast
@@ -0,0 +1,109 @@
+package asciicast
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// PlayOptions controls the timing of a playback.
+type PlayOptions struct {
+	// Speed multiplies the playback speed. Non-positive values mean 1.
+	Speed float64
+	// Seek skips to this point of the recording. Output before it is
+	// emitted at once as a single event so that the screen is complete.
+	Seek time.Duration
+	// MaxIdle caps the pause between two events. Zero means no cap.
+	MaxIdle time.Duration
+}
+
+// Play reads output and resize events from r and passes them to emit with
+// their original timing, adjusted by opts. Input events are skipped. It
+// returns when the recording ends, emit fails or ctx is done.
+func Play(ctx context.Context, r *Reader, opts PlayOptions, emit func(Event) error) error {
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	start := time.Now()
+	var elapsed time.Duration
+	prev := opts.Seek
+
+	var skipped strings.Builder
+	var skippedResize *Event
+	seeking := opts.Seek > 0
+
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if ev.Code != EventOutput && ev.Code != EventResize {
+			continue
+		}
+
+		if seeking {
+			if ev.Time < opts.Seek {
+				if ev.Code == EventResize {
+					resize := ev
+					skippedResize = &resize
+				} else {
+					skipped.WriteString(ev.Data)
+				}
+				continue
+			}
+			seeking = false
+			if err := emitSkipped(skippedResize, skipped.String(), emit); err != nil {
+				return err
+			}
+		}
+
+		gap := ev.Time - prev
+		if gap < 0 {
+			gap = 0
+		}
+		if opts.MaxIdle > 0 && gap > opts.MaxIdle {
+			gap = opts.MaxIdle
+		}
+		prev = ev.Time
+		elapsed += time.Duration(float64(gap) / speed)
+
+		if wait := time.Until(start.Add(elapsed)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		ev.Time = elapsed
+		if err := emit(ev); err != nil {
+			return err
+		}
+	}
+
+	if seeking {
+		return emitSkipped(skippedResize, skipped.String(), emit)
+	}
+	return nil
+}
+
+func emitSkipped(resize *Event, output string, emit func(Event) error) error {
+	if resize != nil {
+		resize.Time = 0
+		if err := emit(*resize); err != nil {
+			return err
+		}
+	}
+	if output != "" {
+		return emit(Event{Code: EventOutput, Data: output})
+	}
+	return nil
+}
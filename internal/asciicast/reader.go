@@ -0,0 +1,99 @@
+package asciicast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineSize bounds the length of a single recorded event line.
+const maxLineSize = 16 * 1024 * 1024
+
+// Event is a single recorded event.
+type Event struct {
+	Time time.Duration
+	Code string
+	Data string
+}
+
+// Size parses the data of a resize event.
+func (e Event) Size() (cols, rows int, err error) {
+	w, h, ok := strings.Cut(e.Data, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid resize event %q", e.Data)
+	}
+	if cols, err = strconv.Atoi(w); err != nil {
+		return 0, 0, fmt.Errorf("invalid resize event %q", e.Data)
+	}
+	if rows, err = strconv.Atoi(h); err != nil {
+		return 0, 0, fmt.Errorf("invalid resize event %q", e.Data)
+	}
+	return cols, rows, nil
+}
+
+// Reader reads events from an asciicast v2 stream.
+type Reader struct {
+	Header Header
+
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader reads and validates the header of an asciicast v2 stream.
+func NewReader(r io.Reader) (*Reader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+
+	rd := &Reader{scanner: scanner}
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty recording")
+	}
+	rd.line++
+	if err := json.Unmarshal(scanner.Bytes(), &rd.Header); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if rd.Header.Version != Version {
+		return nil, fmt.Errorf("unsupported asciicast version %d", rd.Header.Version)
+	}
+	return rd, nil
+}
+
+// Next returns the next event, or io.EOF at the end of the recording.
+func (r *Reader) Next() (Event, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var raw []json.RawMessage
+		if err := json.Unmarshal(line, &raw); err != nil || len(raw) != 3 {
+			return Event{}, fmt.Errorf("invalid event on line %d", r.line)
+		}
+		var ev Event
+		var secs float64
+		if err := json.Unmarshal(raw[0], &secs); err != nil {
+			return Event{}, fmt.Errorf("invalid event time on line %d", r.line)
+		}
+		if err := json.Unmarshal(raw[1], &ev.Code); err != nil {
+			return Event{}, fmt.Errorf("invalid event code on line %d", r.line)
+		}
+		if err := json.Unmarshal(raw[2], &ev.Data); err != nil {
+			return Event{}, fmt.Errorf("invalid event data on line %d", r.line)
+		}
+		ev.Time = time.Duration(secs * float64(time.Second))
+		return ev, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
@@ -0,0 +1,130 @@
+package asciicast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+)
+
+// Version is the asciicast format version written and read by this package.
+const Version = 2
+
+// Event codes defined by the asciicast v2 format.
+const (
+	EventOutput = "o"
+	EventInput  = "i"
+	EventResize = "r"
+)
+
+// Header is the first line of an asciicast v2 recording.
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Writer records events to an asciicast v2 stream. Event times are measured
+// from the creation of the writer. It is safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+
+	// pending holds the incomplete UTF-8 sequence at the end of the last
+	// output and input event, which is prepended to the next one so that
+	// characters are not mangled by JSON string encoding.
+	pending map[string][]byte
+}
+
+// NewWriter writes the header to w and returns a writer for the events.
+// The header version and timestamp are filled in if unset.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	start := time.Now()
+	if h.Version == 0 {
+		h.Version = Version
+	}
+	if h.Timestamp == 0 {
+		h.Timestamp = start.Unix()
+	}
+
+	line, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to write asciicast header: %w", err)
+	}
+
+	return &Writer{
+		w:       w,
+		start:   start,
+		pending: make(map[string][]byte),
+	}, nil
+}
+
+// Output records data written by the terminal.
+func (w *Writer) Output(data []byte) error {
+	return w.data(EventOutput, data)
+}
+
+// Input records data typed into the terminal.
+func (w *Writer) Input(data []byte) error {
+	return w.data(EventInput, data)
+}
+
+// Resize records a change of the terminal size.
+func (w *Writer) Resize(cols, rows int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.event(EventResize, strconv.Itoa(cols)+"x"+strconv.Itoa(rows))
+}
+
+// Flush records any held back partial UTF-8 sequences as they are.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, code := range []string{EventOutput, EventInput} {
+		if p := w.pending[code]; len(p) > 0 {
+			delete(w.pending, code)
+			if err := w.event(code, string(p)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) data(code string, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if p := w.pending[code]; len(p) > 0 {
+		data = append(p, data...)
+	}
+	n := vt.IncompleteUTF8(data)
+	w.pending[code] = append([]byte(nil), data[len(data)-n:]...)
+	data = data[:len(data)-n]
+	if len(data) == 0 {
+		return nil
+	}
+	return w.event(code, string(data))
+}
+
+func (w *Writer) event(code, data string) error {
+	elapsed := time.Since(w.start).Seconds()
+	line, err := json.Marshal([]interface{}{json.Number(strconv.FormatFloat(elapsed, 'f', 6, 64)), code, data})
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top-level configuration.
+type Config struct {
+	Log     LogConfig     `yaml:"log"`
+	HTTP    HTTPConfig    `yaml:"http"`
+	Session SessionConfig `yaml:"session"`
+}
+
+// SessionConfig configures every session.
+type SessionConfig struct {
+	// IdleWarning is how long a session may go without input or output
+	// before subscribers get an idle event. Zero disables idle events.
+	IdleWarning time.Duration `yaml:"idle_warning"`
+	// Rlimits are resource limits set on every session's process.
+	Rlimits RlimitsConfig `yaml:"rlimits"`
+	// Cgroup places every session in a cgroup v2 of its own.
+	Cgroup CgroupConfig `yaml:"cgroup"`
+	// RunAs lists, by user name, the users whose sessions a user may
+	// spawn. "*" stands for every user; as a target it excludes root.
+	RunAs map[string][]string `yaml:"run_as"`
+}
+
+// RlimitsConfig sets resource limits of session processes. Zero or missing
+// values leave the server's limits.
+type RlimitsConfig struct {
+	NProc  uint64        `yaml:"nproc"`
+	NOFile uint64        `yaml:"nofile"`
+	CPU    time.Duration `yaml:"cpu"`
+	// Core is the largest core dump; 0 disables core dumps.
+	Core *ByteSize `yaml:"core"`
+}
+
+// CgroupConfig configures per-session cgroups. Zero limits are not set.
+type CgroupConfig struct {
+	// Parent is the cgroup v2 directory under which session cgroups are
+	// created. Empty disables cgroups.
+	Parent    string   `yaml:"parent"`
+	MemoryMax ByteSize `yaml:"memory_max"`
+	PidsMax   int64    `yaml:"pids_max"`
+	// CPUMax is a number of CPUs, e.g. 0.5.
+	CPUMax float64 `yaml:"cpu_max"`
+}
+
+// HTTPConfig configures the optional HTTP and WebSocket listener.
+type HTTPConfig struct {
+	// Listen is a host:port on a loopback address, or "unix:" followed by a
+	// socket path. Empty disables the listener.
+	Listen string `yaml:"listen"`
+	// Token, if set, is required from every HTTP client. It is required
+	// with a TCP listen address.
+	Token string `yaml:"token"`
+	// User is the name or UID of the user that clients on a TCP port act
+	// as, since they cannot be identified. It is required with a TCP listen
+	// address.
+	User string `yaml:"user"`
+	// AllowedOrigins lists additional browser origins that may open
+	// WebSockets.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// LogConfig configures session logs.
+type LogConfig struct {
+	// Raw enables the raw output log <id>.log.
+	Raw bool `yaml:"raw"`
+	// Plain enables the plain text log <id>.txt with escape sequences
+	// stripped and a timestamp on every line.
+	Plain bool `yaml:"plain"`
+	// MaxSize is the size at which a session log is rotated. Zero disables
+	// rotation.
+	MaxSize ByteSize `yaml:"max_size"`
+	// MaxSegments is the number of rotated segments kept per session.
+	MaxSegments int `yaml:"max_segments"`
+	// Compress gzips rotated segments.
+	Compress bool `yaml:"compress"`
+	// FlushInterval is the longest time output stays buffered in memory.
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	// FlushSize is the amount of buffered output that triggers a flush.
+	FlushSize ByteSize `yaml:"flush_size"`
+	// BufferLimit is the most output buffered while the disk is behind;
+	// output beyond it is dropped from the log.
+	BufferLimit ByteSize `yaml:"buffer_limit"`
+	// Durability is "none", "close" or "flush".
+	Durability string `yaml:"durability"`
+	// Retention limits the logs kept for ended sessions.
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig limits the logs kept in the log directory.
+type RetentionConfig struct {
+	MaxAge       time.Duration `yaml:"max_age"`
+	MaxSessions  int           `yaml:"max_sessions"`
+	MaxTotalSize ByteSize      `yaml:"max_total_size"`
+	Interval     time.Duration `yaml:"interval"`
+}
+
+// Default returns the configuration used when no file is present.
+func Default() *Config {
+	return &Config{
+		Log: LogConfig{
+			Raw:           true,
+			MaxSize:       64 << 20,
+			MaxSegments:   4,
+			Compress:      true,
+			FlushInterval: pty.DefaultFlushInterval,
+			FlushSize:     pty.DefaultFlushSize,
+			BufferLimit:   pty.DefaultBufferLimit,
+			Durability:    string(pty.DurabilityFlush),
+			Retention: RetentionConfig{
+				Interval: 10 * time.Minute,
+			},
+		},
+	}
+}
+
+// Load reads the configuration file at path. Settings missing from the file
+// keep their default values.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.Log.MaxSize < 0 || c.Log.MaxSegments < 0 {
+		return errors.New("log.max_size and log.max_segments must not be negative")
+	}
+	if c.Log.FlushInterval < 0 || c.Log.FlushSize < 0 || c.Log.BufferLimit < 0 {
+		return errors.New("log.flush_interval, log.flush_size and log.buffer_limit must not be negative")
+	}
+	switch pty.Durability(c.Log.Durability) {
+	case pty.DurabilityNone, pty.DurabilityClose, pty.DurabilityFlush:
+	default:
+		return fmt.Errorf("log.durability must be none, close or flush, not %q", c.Log.Durability)
+	}
+	r := c.Log.Retention
+	if r.MaxAge < 0 || r.MaxSessions < 0 || r.MaxTotalSize < 0 || r.Interval < 0 {
+		return errors.New("log.retention values must not be negative")
+	}
+	if c.Session.IdleWarning < 0 {
+		return errors.New("session.idle_warning must not be negative")
+	}
+	if rl := c.Session.Rlimits; rl.CPU < 0 || (rl.Core != nil && *rl.Core < 0) {
+		return errors.New("session.rlimits values must not be negative")
+	}
+	cg := c.Session.Cgroup
+	if cg.MemoryMax < 0 || cg.PidsMax < 0 || cg.CPUMax < 0 {
+		return errors.New("session.cgroup limits must not be negative")
+	}
+	if cg.Parent == "" && (cg.MemoryMax > 0 || cg.PidsMax > 0 || cg.CPUMax > 0) {
+		return errors.New("session.cgroup limits require session.cgroup.parent")
+	}
+	if cg.Parent != "" && !filepath.IsAbs(cg.Parent) {
+		return fmt.Errorf("session.cgroup.parent must be an absolute path, not %q", cg.Parent)
+	}
+	if l := c.HTTP.Listen; l != "" && !strings.HasPrefix(l, "unix:") {
+		host, _, err := net.SplitHostPort(l)
+		if err != nil {
+			return fmt.Errorf("http.listen must be host:port or unix:/path: %w", err)
+		}
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			return fmt.Errorf("http.listen must be on a loopback address, not %q", host)
+		}
+		if c.HTTP.Token == "" {
+			return errors.New("http.token is required when http.listen is a TCP address")
+		}
+		if c.HTTP.User == "" {
+			return errors.New("http.user is required when http.listen is a TCP address")
+		}
+	}
+	return nil
+}
+
+// LogPolicy returns the session log policy described by the configuration.
+func (c *Config) LogPolicy() pty.LogPolicy {
+	return pty.LogPolicy{
+		Raw:             c.Log.Raw,
+		Plain:           c.Log.Plain,
+		MaxSize:         int64(c.Log.MaxSize),
+		MaxSegments:     c.Log.MaxSegments,
+		Compress:        c.Log.Compress,
+		FlushInterval:   c.Log.FlushInterval,
+		FlushSize:       int(c.Log.FlushSize),
+		BufferLimit:     int(c.Log.BufferLimit),
+		Durability:      pty.Durability(c.Log.Durability),
+		MaxAge:          c.Log.Retention.MaxAge,
+		MaxSessions:     c.Log.Retention.MaxSessions,
+		MaxTotalSize:    int64(c.Log.Retention.MaxTotalSize),
+		JanitorInterval: c.Log.Retention.Interval,
+	}
+}
+
+// SessionPolicy returns the session policy described by the configuration.
+func (c *Config) SessionPolicy() pty.SessionPolicy {
+	rlimits := pty.Rlimits{
+		NProc:  c.Session.Rlimits.NProc,
+		NOFile: c.Session.Rlimits.NOFile,
+		CPU:    c.Session.Rlimits.CPU,
+	}
+	if c.Session.Rlimits.Core != nil {
+		core := uint64(*c.Session.Rlimits.Core)
+		rlimits.Core = &core
+	}
+	return pty.SessionPolicy{
+		IdleWarning: c.Session.IdleWarning,
+		Rlimits:     rlimits,
+		Cgroup: pty.CgroupPolicy{
+			Parent:    c.Session.Cgroup.Parent,
+			MemoryMax: int64(c.Session.Cgroup.MemoryMax),
+			PidsMax:   c.Session.Cgroup.PidsMax,
+			CPUMax:    c.Session.Cgroup.CPUMax,
+		},
+		RunAs: pty.RunAsPolicy(c.Session.RunAs),
+	}
+}
@@ -0,0 +1,3 @@
+// Package config loads the webpty-pty YAML configuration file and converts
+// it into the settings used by the other packages.
+package config
@@ -4,8 +4,13 @@ import (
 	"log"
 	"os"
 	"syscall"
+	"time"
 )
 
+// terminateGrace is how long a session's process has to exit after SIGTERM
+// before it is killed.
+const terminateGrace = 100 * time.Millisecond
+
 // CleanupSession performs complete cleanup of a PTY session including closing
 // all file descriptors, removing FIFO files, killing subprocesses, and removing
 // the session from the manager.
@@ -16,6 +21,8 @@ func CleanupSession(sess *Session) {
 
 	log.Printf("[PTY] Cleaning up session %s", sess.ID)
 
+	sess.closeClients()
+
 	if sess.Pty != nil {
 		sess.Pty.Close()
 	}
@@ -24,6 +31,18 @@ func CleanupSession(sess *Session) {
 		sess.logFile.Close()
 	}
 
+	if sess.plainLog != nil {
+		sess.plainLog.Close()
+	}
+
+	if sess.recorder != nil {
+		sess.recorder.Flush()
+	}
+
+	if sess.recordFile != nil {
+		sess.recordFile.Close()
+	}
+
 	if sess.fifoWriter != nil {
 		sess.fifoWriter.Close()
 	}
@@ -34,27 +53,38 @@ func CleanupSession(sess *Session) {
 		}
 	}
 
+	// The process is reaped by the goroutine started in SpawnShell, which
+	// closes exited.
 	if sess.Cmd != nil && sess.Cmd.Process != nil {
-		if err := sess.Cmd.Process.Signal(syscall.SIGTERM); err != nil {
-			log.Printf("[PTY] Warning: failed to send SIGTERM to process %d: %v", sess.Cmd.Process.Pid, err)
-		}
-
-		done := make(chan error, 1)
-		go func() {
-			done <- sess.Cmd.Wait()
-		}()
-
 		select {
-		case <-done:
+		case <-sess.exited:
 		default:
-			if err := sess.Cmd.Process.Kill(); err != nil {
-				log.Printf("[PTY] Warning: failed to kill process %d: %v", sess.Cmd.Process.Pid, err)
+			if err := sess.Cmd.Process.Signal(syscall.SIGTERM); err != nil {
+				log.Printf("[PTY] Warning: failed to send SIGTERM to process %d: %v", sess.Cmd.Process.Pid, err)
+			}
+			select {
+			case <-sess.exited:
+			case <-time.After(terminateGrace):
+				if err := sess.Cmd.Process.Kill(); err != nil {
+					log.Printf("[PTY] Warning: failed to kill process %d: %v", sess.Cmd.Process.Pid, err)
+				}
+				<-sess.exited
 			}
-			sess.Cmd.Wait()
 		}
 	}
 
-	DefaultManager.Remove(sess.ID)
+	if sess.cgroup != "" {
+		if err := removeCgroup(sess.cgroup); err != nil && !os.IsNotExist(err) {
+			log.Printf("[PTY] Warning: failed to remove cgroup %s: %v", sess.cgroup, err)
+		}
+	}
+
+	// CleanupSession may run more than once for a session; only the first
+	// run removes it and reports the exit.
+	if DefaultManager.Remove(sess.ID) {
+		code, signal, _ := sess.ExitStatus()
+		sess.publish(Event{Type: EventExited, ExitCode: code, Signal: signal})
+	}
 	log.Printf("[PTY] Session %s cleaned up", sess.ID)
 }
 
@@ -0,0 +1,96 @@
+package pty
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// clientQueueSize is the number of output chunks buffered per attached client
+// before it is considered too slow and detached.
+const clientQueueSize = 256
+
+var (
+	// ErrDetached is reported by a client that was detached on request.
+	ErrDetached = errors.New("detached")
+	// ErrClientTooSlow is reported by a client that fell too far behind the
+	// output stream and was detached to protect the session.
+	ErrClientTooSlow = errors.New("client too slow")
+)
+
+// Output is a chunk of PTY output together with the absolute offset of its
+// first byte.
+type Output struct {
+	Offset int64
+	Data   []byte
+}
+
+// Client is a consumer attached to a session's live output stream.
+type Client struct {
+	ID         string
+	UID        int
+	PID        int
+	AttachedAt time.Time
+
+	sess   *Session
+	output chan Output
+	done   chan struct{}
+	err    error
+	once   sync.Once
+}
+
+func newClient(sess *Session, uid, pid int) *Client {
+	return &Client{
+		ID:         uuid.New().String(),
+		UID:        uid,
+		PID:        pid,
+		AttachedAt: time.Now(),
+		sess:       sess,
+		output:     make(chan Output, clientQueueSize),
+		done:       make(chan struct{}),
+	}
+}
+
+// Output returns the channel on which live output is delivered.
+func (c *Client) Output() <-chan Output {
+	return c.output
+}
+
+// Done returns a channel that is closed once the client has been detached.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the client was detached, or nil while still attached.
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Detach detaches the client from its session. The session keeps running.
+func (c *Client) Detach() {
+	c.sess.Detach(c.ID)
+}
+
+// close marks the client detached with the given reason. The output channel
+// is left open so that chunks already queued can still be drained.
+func (c *Client) close(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+	})
+}
+
+// ClientInfo describes an attached client.
+type ClientInfo struct {
+	ID         string
+	UID        int
+	PID        int
+	AttachedAt time.Time
+}
@@ -0,0 +1,103 @@
+package pty
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code classifies an error so that every API reports it the same way.
+type Code string
+
+const (
+	// CodeNotFound means the session, client, watch or file does not exist.
+	CodeNotFound Code = "NOT_FOUND"
+	// CodeInvalidArgument means the request was malformed or not allowed in
+	// the current state.
+	CodeInvalidArgument Code = "INVALID_ARGUMENT"
+	// CodePermissionDenied means the caller may not access the resource.
+	CodePermissionDenied Code = "PERMISSION_DENIED"
+	// CodeQuotaExceeded means a limit on sessions or resources was reached.
+	CodeQuotaExceeded Code = "QUOTA_EXCEEDED"
+	// CodeSessionExited means the session has already ended.
+	CodeSessionExited Code = "SESSION_EXITED"
+	// CodeBusy means the session is running a job that the request would
+	// interrupt; it can be repeated with force.
+	CodeBusy Code = "BUSY"
+	// CodeInternal means the server failed; errors without a code have it.
+	CodeInternal Code = "INTERNAL"
+)
+
+// Error is an error with a Code and optional details for clients.
+type Error struct {
+	Code    Code
+	Message string
+	// Details holds machine-readable context, such as the name of an
+	// invalid field.
+	Details map[string]interface{}
+	// Err is the underlying error, if any. Its message is appended to
+	// Message.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewError returns an error with the given code and message.
+func NewError(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// WrapError returns an error with the given code that adds message to err.
+func WrapError(code Code, err error, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// InvalidField returns an INVALID_ARGUMENT error about the named request
+// field.
+func InvalidField(field, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:    CodeInvalidArgument,
+		Message: fmt.Sprintf(format, args...),
+		Details: map[string]interface{}{"field": field},
+	}
+}
+
+// CodeOf returns the code of the first Error in err's chain, or
+// CodeInternal.
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeInternal
+}
+
+// DetailsOf returns the details of the first Error in err's chain.
+func DetailsOf(err error) map[string]interface{} {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Details
+	}
+	return nil
+}
+
+var (
+	// ErrSessionNotFound is returned for a session ID that is not running.
+	ErrSessionNotFound = NewError(CodeNotFound, "session not found")
+	// ErrPermissionDenied is returned when a client may not access a session.
+	ErrPermissionDenied = NewError(CodePermissionDenied, "permission denied")
+	// ErrSessionClosed is returned for a session that has ended, and
+	// reported by its clients and watches.
+	ErrSessionClosed = NewError(CodeSessionExited, "session closed")
+	// ErrNoEmulator is returned when a screen snapshot is requested from a
+	// session spawned without a terminal emulator.
+	ErrNoEmulator = NewError(CodeInvalidArgument, "terminal emulator not enabled for session")
+)
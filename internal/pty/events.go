@@ -0,0 +1,211 @@
+package pty
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// subscriptionQueueSize is the number of events buffered per subscription
+// before its consumer is considered too slow and the subscription is ended.
+const subscriptionQueueSize = 256
+
+// ErrUnsubscribed ends a subscription that was removed on request.
+var ErrUnsubscribed = errors.New("removed")
+
+// EventType names a session lifecycle event.
+type EventType string
+
+const (
+	// EventSpawned is published once a session has started.
+	EventSpawned EventType = "spawned"
+	// EventExited is published once a session has ended and been cleaned
+	// up, with the exit status of its process.
+	EventExited EventType = "exited"
+	// EventResized is published when a session's terminal is resized.
+	EventResized EventType = "resized"
+	// EventAttached and EventDetached are published when a client attaches
+	// to or detaches from a session.
+	EventAttached EventType = "attached"
+	EventDetached EventType = "detached"
+	// EventTitle is published when a session's window title changes.
+	EventTitle EventType = "title"
+	// EventBell is published when a session rings the bell, at most every
+	// bellInterval.
+	EventBell EventType = "bell"
+	// EventIdle is published when a session has had no input or output for
+	// the idle warning period of DefaultSessionPolicy. It is published again
+	// only after the session has been active in between.
+	EventIdle EventType = "idle"
+)
+
+// Event is a change in the lifecycle of a session. Which of the optional
+// fields are set depends on Type.
+type Event struct {
+	Type   EventType
+	ID     string
+	Owner  int
+	Labels map[string]string
+	Time   time.Time
+
+	// ExitCode and Signal are the exit status of an exited session, as
+	// reported by ExitStatus.
+	ExitCode int
+	Signal   string
+	// Cols and Rows are the size of a spawned or resized session.
+	Cols int
+	Rows int
+	// ClientID identifies the client that attached or detached, and Reason
+	// why it detached.
+	ClientID string
+	Reason   string
+	// Title is the new window title.
+	Title string
+	// Idle is how long an idle session has been inactive.
+	Idle time.Duration
+}
+
+// EventFilter selects the events a subscription receives. Events of
+// sessions the subscriber may not attach to are never delivered.
+type EventFilter struct {
+	// UID is the user subscribing.
+	UID int
+	// ID, if set, selects the events of one session. The subscription
+	// then ends with ErrSessionClosed after the session's EventExited.
+	ID string
+	// Labels, if set, selects the events of sessions carrying all of these
+	// labels with the same values.
+	Labels map[string]string
+}
+
+func (f EventFilter) matches(ev Event) bool {
+	if f.UID != 0 && f.UID != ev.Owner {
+		return false
+	}
+	if f.ID != "" && f.ID != ev.ID {
+		return false
+	}
+	for k, v := range f.Labels {
+		if label, ok := ev.Labels[k]; !ok || label != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscription delivers the session events that match its filter.
+type Subscription struct {
+	ID string
+
+	bus    *EventBus
+	filter EventFilter
+	events chan Event
+	done   chan struct{}
+	err    error
+	once   sync.Once
+}
+
+// Events returns the channel on which events are delivered.
+func (sub *Subscription) Events() <-chan Event {
+	return sub.events
+}
+
+// Done returns a channel that is closed once the subscription has ended.
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Err returns why the subscription ended, or nil while it is active.
+func (sub *Subscription) Err() error {
+	select {
+	case <-sub.done:
+		return sub.err
+	default:
+		return nil
+	}
+}
+
+// Unsubscribe ends the subscription.
+func (sub *Subscription) Unsubscribe() {
+	sub.bus.end(sub, ErrUnsubscribed)
+}
+
+// close marks the subscription ended with the given reason. The event
+// channel is left open so that events already queued can still be drained.
+func (sub *Subscription) close(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+// EventBus fans session events out to subscribers. Publishing never blocks:
+// a subscriber that falls behind is dropped.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[string]*Subscription
+}
+
+// DefaultEventBus is the bus on which sessions publish their events.
+var DefaultEventBus = &EventBus{subs: make(map[string]*Subscription)}
+
+// Subscribe registers a subscription for the events matching filter.
+func (b *EventBus) Subscribe(filter EventFilter) *Subscription {
+	sub := &Subscription{
+		ID:     uuid.New().String(),
+		bus:    b,
+		filter: filter,
+		events: make(chan Event, subscriptionQueueSize),
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.subs[sub.ID] = sub
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish delivers ev to every subscription whose filter it matches.
+func (b *EventBus) Publish(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, sub := range b.subs {
+		if !sub.filter.matches(ev) {
+			continue
+		}
+		select {
+		case sub.events <- ev:
+		default:
+			b.endLocked(sub, ErrClientTooSlow)
+			continue
+		}
+		if ev.Type == EventExited && sub.filter.ID != "" {
+			b.endLocked(sub, ErrSessionClosed)
+		}
+	}
+}
+
+func (b *EventBus) end(sub *Subscription, reason error) {
+	b.mu.Lock()
+	b.endLocked(sub, reason)
+	b.mu.Unlock()
+}
+
+func (b *EventBus) endLocked(sub *Subscription, reason error) {
+	if b.subs[sub.ID] == sub {
+		delete(b.subs, sub.ID)
+	}
+	sub.close(reason)
+}
+
+// publish publishes an event of the session on DefaultEventBus.
+func (s *Session) publish(ev Event) {
+	ev.ID = s.ID
+	ev.Owner = s.Owner
+	ev.Labels = s.Labels
+	DefaultEventBus.Publish(ev)
+}
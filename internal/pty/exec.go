@@ -0,0 +1,125 @@
+package pty
+
+import (
+	"bytes"
+	"log"
+	"syscall"
+	"time"
+)
+
+// execDrainTimeout is how long Exec keeps collecting output after the
+// command has exited, in case a background process it started holds the
+// terminal open.
+const execDrainTimeout = time.Second
+
+// ErrNoCommand is returned by Exec when no command is given.
+var ErrNoCommand = InvalidField("command", "command is required")
+
+// ExecOptions configures a command run by Exec.
+type ExecOptions struct {
+	Owner   int
+	Command []string
+	Cols    int
+	Rows    int
+	// Stdin is typed into the terminal once the command has started. EOF
+	// then signals end of input the way Ctrl-D does at a terminal.
+	Stdin []byte
+	EOF   bool
+	// Timeout kills the command if it has not exited in time. Zero waits
+	// forever.
+	Timeout time.Duration
+	// MaxOutput is the number of bytes of output kept. When the command
+	// prints more, the beginning is discarded.
+	MaxOutput int
+}
+
+// ExecResult is the outcome of a command run by Exec.
+type ExecResult struct {
+	ID        string
+	Output    []byte
+	Truncated bool
+	// ExitCode is -1 if the command was terminated by a signal, whose name
+	// is in Signal.
+	ExitCode int
+	Signal   string
+	TimedOut bool
+	Duration time.Duration
+}
+
+// Exec runs a command in a new session, waits for it to exit and returns its
+// output and exit status. The session is cleaned up before Exec returns, and
+// processes the command left running in its process group are killed.
+// While the command runs, the session is listed like any other, so it can
+// be attached to or killed.
+func Exec(opts ExecOptions) (*ExecResult, error) {
+	if len(opts.Command) == 0 {
+		return nil, ErrNoCommand
+	}
+
+	started := time.Now()
+	sess, err := SpawnShell(SpawnOptions{
+		Owner:          opts.Owner,
+		Cols:           opts.Cols,
+		Rows:           opts.Rows,
+		Command:        opts.Command,
+		ScrollbackSize: opts.MaxOutput,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	input := opts.Stdin
+	if opts.EOF {
+		input = append(append([]byte(nil), input...), eofSequence(input)...)
+	}
+	if len(input) > 0 {
+		if _, err := sess.Write(input); err != nil {
+			log.Printf("[PTY] Session %s: exec input failed: %v", sess.ID, err)
+		}
+	}
+
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	res := &ExecResult{ID: sess.ID}
+	select {
+	case <-sess.Exited():
+		select {
+		case <-sess.done:
+		case <-time.After(execDrainTimeout):
+		}
+	case <-timeout:
+		res.TimedOut = true
+	}
+
+	CleanupSession(sess)
+	sess.Wait()
+	// The command led its own process group; take down whatever it left
+	// running in the background.
+	if err := syscall.Kill(-sess.Cmd.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Printf("[PTY] Session %s: failed to kill process group: %v", sess.ID, err)
+	}
+	res.Duration = time.Since(started)
+
+	sess.mu.Lock()
+	res.Output, _ = sess.scrollback.Since(-1)
+	res.Truncated = sess.scrollback.Start() > 0
+	sess.mu.Unlock()
+
+	res.ExitCode, res.Signal, _ = sess.ExitStatus()
+	return res, nil
+}
+
+// eofSequence returns the input that signals end of file after input to a
+// terminal in canonical mode: Ctrl-D at the start of a line, or twice to
+// first flush an unterminated line.
+func eofSequence(input []byte) []byte {
+	if len(input) == 0 || bytes.HasSuffix(input, []byte("\n")) {
+		return []byte{0x04}
+	}
+	return []byte{0x04, 0x04}
+}
@@ -0,0 +1,145 @@
+package pty
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+)
+
+// oscShellIntegration is the OSC code of the FinalTerm shell integration
+// markers: A before the prompt, B after it, C when the command line is
+// submitted and D;<exit code> when the command has finished. C may carry
+// the command line as cmdline_url=<percent-encoded text>, as kitty's shell
+// integration and ours send it.
+const oscShellIntegration = 133
+
+// maxHistory is the number of finished commands kept per session.
+const maxHistory = 1000
+
+// maxCommandLength bounds the command line text kept per command.
+const maxCommandLength = 4096
+
+// Command is a command run in a session's shell, as delimited by the shell
+// integration markers.
+type Command struct {
+	// Command is the command line as the shell reported it, or else as it
+	// was echoed.
+	Command string
+	// Start is the offset at which the command's output begins, and End the
+	// offset at which the shell reported it finished, or -1 while it runs.
+	Start int64
+	End   int64
+	// ExitCode is nil if the shell did not report it or the command is
+	// still running.
+	ExitCode   *int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Running reports whether the command has not finished yet.
+func (c Command) Running() bool {
+	return c.End < 0
+}
+
+// History returns the commands run in the session, oldest first, followed
+// by the running command, if any.
+func (s *Session) History() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history := make([]Command, len(s.history), len(s.history)+1)
+	copy(history, s.history)
+	if s.running != nil {
+		history = append(history, *s.running)
+	}
+	return history
+}
+
+// handleMarkLocked records a shell integration marker reported just before
+// offset. s.mu must be held.
+func (s *Session) handleMarkLocked(text string, offset int64) {
+	mark, args, _ := strings.Cut(text, ";")
+	switch mark {
+	case "A":
+		// A new prompt without D means the command's end was not reported.
+		s.finishCommandLocked(nil, offset)
+		s.inputStart = -1
+	case "B":
+		s.inputStart = offset
+	case "C":
+		s.finishCommandLocked(nil, offset)
+		line, ok := reportedCommandLine(args)
+		if !ok {
+			line = s.commandLineLocked(offset)
+		}
+		s.running = &Command{
+			Command:   line,
+			Start:     offset,
+			End:       -1,
+			StartedAt: time.Now(),
+		}
+		s.inputStart = -1
+	case "D":
+		var exitCode *int
+		codeText, _, _ := strings.Cut(args, ";")
+		if code, err := strconv.Atoi(codeText); err == nil {
+			exitCode = &code
+		}
+		s.finishCommandLocked(exitCode, offset)
+	}
+}
+
+// reportedCommandLine returns the command line carried by the arguments of
+// a C marker.
+func reportedCommandLine(args string) (string, bool) {
+	for _, arg := range strings.Split(args, ";") {
+		if value, ok := strings.CutPrefix(arg, "cmdline_url="); ok {
+			line, err := url.PathUnescape(value)
+			if err != nil {
+				return "", false
+			}
+			return truncateCommandLine(line), true
+		}
+	}
+	return "", false
+}
+
+// commandLineLocked returns the text echoed between the B marker and
+// offset, with escape sequences stripped. s.mu must be held.
+func (s *Session) commandLineLocked(offset int64) string {
+	if s.inputStart < 0 {
+		return ""
+	}
+	data, start := s.scrollback.Since(s.inputStart)
+	if n := offset - start; n >= 0 && n <= int64(len(data)) {
+		data = data[:n]
+	}
+	return truncateCommandLine(strings.TrimSpace(vt.Strip(data)))
+}
+
+func truncateCommandLine(line string) string {
+	if len(line) > maxCommandLength {
+		line = strings.ToValidUTF8(line[:maxCommandLength], "")
+	}
+	return line
+}
+
+// finishCommandLocked ends the running command, if any, and adds it to the
+// history. s.mu must be held.
+func (s *Session) finishCommandLocked(exitCode *int, offset int64) {
+	if s.running == nil {
+		return
+	}
+	cmd := *s.running
+	s.running = nil
+	cmd.End = offset
+	cmd.ExitCode = exitCode
+	cmd.FinishedAt = time.Now()
+	if len(s.history) == maxHistory {
+		copy(s.history, s.history[1:])
+		s.history = s.history[:maxHistory-1]
+	}
+	s.history = append(s.history, cmd)
+}
@@ -0,0 +1,126 @@
+package pty
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// integrationFiles are the startup files that load the user's own and add
+// the shell integration markers.
+//
+//go:embed integration/bash.sh integration/zsh/.zshenv integration/zsh/.zshrc
+var integrationFiles embed.FS
+
+// sharedIntegrationDir holds the shell integration files for sessions that
+// run as another user than the server, who cannot read the server's home.
+const sharedIntegrationDir = "/run/webpty/integration"
+
+// integrationDir returns the directory the shell integration files of a
+// session are installed in: ~/.webpty/integration if the session runs as
+// the server's user, or else sharedIntegrationDir, which only root may
+// write and everyone may read.
+func integrationDir(shared bool) (string, error) {
+	if !shared {
+		return expandPath("~/.webpty/integration")
+	}
+	if err := os.MkdirAll(sharedIntegrationDir, 0755); err != nil {
+		return "", err
+	}
+	// Another user must not be able to replace the files shells of
+	// everyone load.
+	for _, dir := range []string{filepath.Dir(sharedIntegrationDir), sharedIntegrationDir} {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return "", err
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !info.IsDir() || !ok || st.Uid != 0 || info.Mode().Perm()&0022 != 0 {
+			return "", fmt.Errorf("%s must be a directory owned by root and writable only by it", dir)
+		}
+	}
+	return sharedIntegrationDir, nil
+}
+
+// installIntegration writes the shell integration files to dir, readable by
+// everyone. Files are replaced atomically, so shells starting meanwhile
+// never read partial files.
+func installIntegration(dir string) error {
+	return fs.WalkDir(integrationFiles, "integration", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := integrationFiles.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel("integration", path)
+		dst := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Chmod(0644); err != nil {
+			tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), dst)
+	})
+}
+
+// addIntegration changes cmd, which starts the shell at shellPath, to load
+// the shell integration. shared installs the files where users other than
+// the server's can read them. It reports false for shells other than bash
+// and zsh, which are left unchanged.
+func addIntegration(cmd *exec.Cmd, shellPath string, shared bool) (bool, error) {
+	shell := filepath.Base(shellPath)
+	if shell != "bash" && shell != "zsh" {
+		return false, nil
+	}
+	dir, err := integrationDir(shared)
+	if err != nil {
+		return false, err
+	}
+	if err := installIntegration(dir); err != nil {
+		return false, err
+	}
+	switch shell {
+	case "bash":
+		cmd.Args = append(cmd.Args, "--rcfile", filepath.Join(dir, "bash.sh"))
+	case "zsh":
+		cmd.Env = append(cmd.Env,
+			"ZDOTDIR="+filepath.Join(dir, "zsh"),
+			"WEBPTY_USER_ZDOTDIR="+lookupEnv(cmd.Env, "ZDOTDIR"))
+	}
+	return true, nil
+}
+
+// lookupEnv returns the value of key in env, a list of key=value pairs. As
+// in the environment a process receives, the last occurrence wins.
+func lookupEnv(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], key+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
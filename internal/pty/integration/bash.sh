@@ -0,0 +1,54 @@
+# webpty shell integration for bash, loaded with --rcfile in place of
+# ~/.bashrc. It marks prompts and commands with OSC 133 and reports the
+# working directory with OSC 7.
+
+if [ -r /etc/bash.bashrc ]; then
+	. /etc/bash.bashrc
+fi
+if [ -r ~/.bashrc ]; then
+	. ~/.bashrc
+fi
+
+# __webpty_urlencode prints its argument percent-encoded.
+__webpty_urlencode() {
+	local LC_ALL=C s=$1 out= c i
+	for ((i = 0; i < ${#s}; i++)); do
+		c=${s:i:1}
+		case $c in
+		[a-zA-Z0-9./~_-]) out+=$c ;;
+		*) printf -v c '%%%02X' "'$c" && out+=$c ;;
+		esac
+	done
+	printf '%s' "$out"
+}
+
+# __webpty_precmd runs first in PROMPT_COMMAND to capture the exit status of
+# the command that just finished. It keeps $? for the commands after it.
+__webpty_precmd() {
+	local ret=$?
+	printf '\033]133;D;%s\007\033]7;file://%s%s\007' "$ret" "$HOSTNAME" "$(__webpty_urlencode "$PWD")"
+	return $ret
+}
+
+# __webpty_ps1 runs last, so that prompts set by other PROMPT_COMMAND
+# entries are marked too.
+__webpty_ps1() {
+	local ret=$?
+	case "$PS1" in
+	*'133;A'*) ;;
+	*) PS1='\[\e]133;A\a\]'"$PS1"'\[\e]133;B\a\]' ;;
+	esac
+	return $ret
+}
+
+# __webpty_preexec runs from PS0 when a command line is submitted, and
+# reports it as found in the history.
+__webpty_preexec() {
+	local line
+	line=$(HISTTIMEFORMAT= builtin history 1)
+	line=${line#*[0-9]  }
+	printf '\033]133;C;cmdline_url=%s\007' "$(__webpty_urlencode "$line")"
+}
+
+PROMPT_COMMAND="__webpty_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND};__webpty_ps1"
+PS0='$(__webpty_preexec)'"$PS0"
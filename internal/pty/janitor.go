@@ -0,0 +1,130 @@
+package pty
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// janitorGrace is how long files are left alone after they were last
+// modified. A session writes its metadata and logs before it is added to
+// DefaultManager, and must not lose them in between.
+const janitorGrace = time.Minute
+
+// logSet is the group of files in the log directory that belong to one
+// session: its logs, rotated segments, recording and metadata.
+type logSet struct {
+	id      string
+	paths   []string
+	size    int64
+	modTime time.Time
+}
+
+// StartLogJanitor periodically enforces the retention limits of policy on
+// the log directory dir until the returned stop function is called. Files of
+// running sessions, and files modified within janitorGrace, are never
+// deleted.
+func StartLogJanitor(dir string, policy LogPolicy) (stop func()) {
+	done := make(chan struct{})
+	if policy.JanitorInterval <= 0 ||
+		(policy.MaxAge <= 0 && policy.MaxSessions <= 0 && policy.MaxTotalSize <= 0) {
+		return func() {}
+	}
+
+	go func() {
+		ticker := time.NewTicker(policy.JanitorInterval)
+		defer ticker.Stop()
+		for {
+			if err := CleanupLogs(dir, policy); err != nil {
+				log.Printf("[PTY] Warning: log janitor failed: %v", err)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// CleanupLogs deletes the logs of ended sessions in dir that exceed the
+// retention limits of policy: first those older than MaxAge, then all but
+// the newest MaxSessions, then the oldest until the directory fits within
+// MaxTotalSize.
+func CleanupLogs(dir string, policy LogPolicy) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	sets := make(map[string]*logSet)
+	var total int64
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+
+		id, _, _ := strings.Cut(entry.Name(), ".")
+		if id == "" || DefaultManager.Get(id) != nil {
+			continue
+		}
+		set := sets[id]
+		if set == nil {
+			set = &logSet{id: id}
+			sets[id] = set
+		}
+		set.paths = append(set.paths, filepath.Join(dir, entry.Name()))
+		set.size += info.Size()
+		if info.ModTime().After(set.modTime) {
+			set.modTime = info.ModTime()
+		}
+	}
+
+	ended := make([]*logSet, 0, len(sets))
+	for _, set := range sets {
+		if time.Since(set.modTime) < janitorGrace {
+			continue
+		}
+		ended = append(ended, set)
+	}
+	// Newest first.
+	sort.Slice(ended, func(i, j int) bool {
+		return ended[i].modTime.After(ended[j].modTime)
+	})
+
+	remove := func(set *logSet, reason string) {
+		for _, path := range set.paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				log.Printf("[PTY] Warning: failed to remove log %s: %v", path, err)
+			}
+		}
+		total -= set.size
+		log.Printf("[PTY] Removed logs of session %s (%s)", set.id, reason)
+	}
+
+	kept := ended[:0]
+	for _, set := range ended {
+		switch {
+		case policy.MaxAge > 0 && time.Since(set.modTime) > policy.MaxAge:
+			remove(set, "max age")
+		case policy.MaxSessions > 0 && len(kept) >= policy.MaxSessions:
+			remove(set, "max sessions")
+		default:
+			kept = append(kept, set)
+		}
+	}
+
+	for i := len(kept) - 1; i >= 0 && policy.MaxTotalSize > 0 && total > policy.MaxTotalSize; i-- {
+		remove(kept[i], "max total size")
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package pty
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCleanupLogs(t *testing.T) {
+	type file struct {
+		name string
+		size int
+		age  time.Duration
+	}
+	tests := []struct {
+		name   string
+		policy LogPolicy
+		files  []file
+		want   []string
+	}{
+		{
+			name:   "max age",
+			policy: LogPolicy{MaxAge: time.Hour},
+			files: []file{
+				{"old.log", 10, 2 * time.Hour},
+				{"old.log.1", 10, 3 * time.Hour},
+				{"new.log", 10, 30 * time.Minute},
+			},
+			want: []string{"new.log"},
+		},
+		{
+			name:   "newest file of a session counts",
+			policy: LogPolicy{MaxAge: time.Hour},
+			files: []file{
+				{"a.log", 10, 10 * time.Minute},
+				{"a.log.1", 10, 2 * time.Hour},
+			},
+			want: []string{"a.log", "a.log.1"},
+		},
+		{
+			name:   "max sessions",
+			policy: LogPolicy{MaxSessions: 2},
+			files: []file{
+				{"a.log", 10, 10 * time.Minute},
+				{"a.cast", 10, 10 * time.Minute},
+				{"b.log", 10, 20 * time.Minute},
+				{"c.log", 10, 30 * time.Minute},
+			},
+			want: []string{"a.cast", "a.log", "b.log"},
+		},
+		{
+			name:   "max total size",
+			policy: LogPolicy{MaxTotalSize: 250},
+			files: []file{
+				{"a.log", 100, 10 * time.Minute},
+				{"b.log", 100, 20 * time.Minute},
+				{"c.log", 100, 30 * time.Minute},
+			},
+			want: []string{"a.log", "b.log"},
+		},
+		{
+			name:   "fresh files are spared",
+			policy: LogPolicy{MaxAge: time.Nanosecond, MaxSessions: 1},
+			files: []file{
+				{"a.log", 10, 0},
+				{"b.log", 10, 10 * time.Second},
+				{"c.log", 10, 2 * janitorGrace},
+			},
+			want: []string{"a.log", "b.log"},
+		},
+		{
+			name:   "no limits",
+			policy: LogPolicy{},
+			files: []file{
+				{"a.log", 10, 10 * time.Hour},
+			},
+			want: []string{"a.log"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				path := filepath.Join(dir, f.name)
+				if err := os.WriteFile(path, []byte(strings.Repeat("x", f.size)), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-f.age)
+				if err := os.Chtimes(path, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := CleanupLogs(dir, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(entries))
+			for _, e := range entries {
+				got = append(got, e.Name())
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package pty
+
+import (
+	"time"
+)
+
+// Rlimits are resource limits set on the process of every session, as both
+// its soft and hard limit so that the session cannot raise them again.
+// Children inherit them.
+type Rlimits struct {
+	// NProc limits the number of processes of the session's user,
+	// counting processes outside the session too. It does not apply to
+	// root; a cgroup's PidsMax does.
+	NProc uint64
+	// NOFile limits the number of open files per process.
+	NOFile uint64
+	// CPU limits the CPU time per process, in whole seconds.
+	CPU time.Duration
+	// Core limits the size of core dumps in bytes; zero disables them.
+	// Nil leaves the server's limit.
+	Core *uint64
+}
+
+func (r Rlimits) empty() bool {
+	return r.NProc == 0 && r.NOFile == 0 && r.CPU == 0 && r.Core == nil
+}
+
+// CgroupPolicy places every session in a cgroup v2 of its own, which limits
+// the session's processes together and is removed, killing whatever is left
+// in it, when the session is cleaned up.
+type CgroupPolicy struct {
+	// Parent is the cgroup directory under which session cgroups are
+	// created, such as a subtree delegated to the server by systemd. Empty
+	// disables cgroups.
+	Parent string
+	// MemoryMax sets memory.max in bytes.
+	MemoryMax int64
+	// PidsMax sets pids.max.
+	PidsMax int64
+	// CPUMax sets cpu.max as a number of CPUs, e.g. 0.5 for half of one.
+	CPUMax float64
+}
+
+// cgroupPeriod is the cpu.max period, in microseconds, over which CPUMax is
+// enforced.
+const cgroupPeriod = 100000
+
+// ResourceUsage is the resource usage of a session's cgroup.
+type ResourceUsage struct {
+	// Memory and MemoryPeak are the current and highest memory use in
+	// bytes, from memory.current and memory.peak.
+	Memory     int64
+	MemoryPeak int64
+	// Pids is the number of processes and threads.
+	Pids int64
+	// CPU is the CPU time used by all processes of the session, including
+	// exited ones.
+	CPU time.Duration
+	// OOMKills counts processes killed for exceeding MemoryMax.
+	OOMKills int64
+}
+
+// Usage returns the resource usage of the session. It reports false if the
+// session has no cgroup. Counters of controllers not enabled for the cgroup
+// are zero.
+func (s *Session) Usage() (ResourceUsage, bool) {
+	if s.cgroup == "" {
+		return ResourceUsage{}, false
+	}
+	return cgroupUsage(s.cgroup), true
+}
@@ -0,0 +1,266 @@
+//go:build linux
+
+package pty
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupRemoveTimeout is how long removeCgroup waits for the processes of a
+// cgroup to die after killing them.
+const cgroupRemoveTimeout = time.Second
+
+// rlimitHelper is the argv[0] with which the server runs itself to start a
+// process with resource limits.
+const rlimitHelper = "webpty-pty-rlimit"
+
+// rlimitCommand makes cmd start its program with limits applied.
+//
+// SysProcAttr cannot carry resource limits, and setting them on the server
+// around the fork would apply them to the server itself. Instead cmd runs
+// the server's own executable as a helper, which sets the limits and then
+// executes the program, so that the program and everything it forks start
+// with the limits in place.
+func rlimitCommand(cmd *exec.Cmd, limits Rlimits) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the server executable: %w", err)
+	}
+
+	args := []string{rlimitHelper}
+	add := func(resource int, value uint64) {
+		args = append(args, fmt.Sprintf("%d=%d", resource, value))
+	}
+	// RLIMIT_NPROC is missing from the syscall package.
+	const rlimitNProc = 6
+	if limits.NProc > 0 {
+		add(rlimitNProc, limits.NProc)
+	}
+	if limits.NOFile > 0 {
+		add(syscall.RLIMIT_NOFILE, limits.NOFile)
+	}
+	if limits.CPU > 0 {
+		add(syscall.RLIMIT_CPU, uint64((limits.CPU+time.Second-1)/time.Second))
+	}
+	if limits.Core != nil {
+		add(syscall.RLIMIT_CORE, *limits.Core)
+	}
+	args = append(args, "--", cmd.Path)
+	cmd.Path, cmd.Args = self, append(args, cmd.Args...)
+	return nil
+}
+
+// RunHelper runs the helper with which the server starts processes with
+// resource limits, if the process was started as one, and does not return
+// then. The server calls it first thing.
+func RunHelper() {
+	if len(os.Args) == 0 || os.Args[0] != rlimitHelper {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "webpty-pty: "+format+"\n", args...)
+		os.Exit(127)
+	}
+
+	// webpty-pty-rlimit resource=value... -- path argv...
+	args := os.Args[1:]
+	for len(args) > 0 && args[0] != "--" {
+		resource, value, _ := strings.Cut(args[0], "=")
+		r, err := strconv.Atoi(resource)
+		if err != nil {
+			fail("invalid resource limit %q", args[0])
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			fail("invalid resource limit %q", args[0])
+		}
+		if err := syscall.Setrlimit(r, &syscall.Rlimit{Cur: v, Max: v}); err != nil {
+			fail("failed to set resource limit %d: %v", r, err)
+		}
+		args = args[1:]
+	}
+	if len(args) < 3 {
+		fail("no command to run")
+	}
+	err := syscall.Exec(args[1], args[2:], os.Environ())
+	fail("failed to run %s: %v", args[1], err)
+}
+
+// createCgroup creates the cgroup of a session under policy.Parent and
+// writes its limits. It returns the cgroup's path and an open descriptor of
+// it, with which the process is started inside it.
+func createCgroup(id string, policy CgroupPolicy) (string, *os.File, error) {
+	if err := os.MkdirAll(policy.Parent, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create cgroup %s: %w", policy.Parent, err)
+	}
+
+	// Limits can only be set in a child if the parent delegates the
+	// controller, which a cgroup containing processes cannot do.
+	if err := leaveCgroup(policy.Parent); err != nil {
+		return "", nil, err
+	}
+	var controllers []string
+	if policy.MemoryMax > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if policy.PidsMax > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	if policy.CPUMax > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if len(controllers) > 0 {
+		if err := writeCgroupFile(policy.Parent, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+			return "", nil, fmt.Errorf("failed to enable cgroup controllers: %w", err)
+		}
+	}
+
+	dir := filepath.Join(policy.Parent, "session-"+id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create cgroup %s: %w", dir, err)
+	}
+
+	var err error
+	if policy.MemoryMax > 0 {
+		err = writeCgroupFile(dir, "memory.max", strconv.FormatInt(policy.MemoryMax, 10))
+	}
+	if err == nil && policy.PidsMax > 0 {
+		err = writeCgroupFile(dir, "pids.max", strconv.FormatInt(policy.PidsMax, 10))
+	}
+	if err == nil && policy.CPUMax > 0 {
+		quota := int64(policy.CPUMax * cgroupPeriod)
+		if quota < 1000 {
+			quota = 1000
+		}
+		err = writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupPeriod))
+	}
+	if err != nil {
+		os.Remove(dir)
+		return "", nil, fmt.Errorf("failed to set cgroup limits: %w", err)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return "", nil, fmt.Errorf("failed to open cgroup %s: %w", dir, err)
+	}
+	return dir, f, nil
+}
+
+// serverCgroup is the name of the cgroup the server moves itself to when it
+// runs in the parent of the session cgroups.
+const serverCgroup = "server"
+
+// leaveCgroup moves the server out of parent into a child of its own if it
+// runs in parent, as it does when parent is the cgroup systemd delegated to
+// the service. Only cgroups without processes of their own can enable
+// controllers for their children.
+func leaveCgroup(parent string) error {
+	procs, err := os.ReadFile(filepath.Join(parent, "cgroup.procs"))
+	if err != nil {
+		return fmt.Errorf("failed to read cgroup %s: %w", parent, err)
+	}
+	self := strconv.Itoa(os.Getpid())
+	for _, pid := range strings.Fields(string(procs)) {
+		if pid != self {
+			continue
+		}
+		dir := filepath.Join(parent, serverCgroup)
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create cgroup %s: %w", dir, err)
+		}
+		if err := writeCgroupFile(dir, "cgroup.procs", self); err != nil {
+			return fmt.Errorf("failed to move the server to cgroup %s: %w", dir, err)
+		}
+		log.Printf("[PTY] Moved the server to cgroup %s", dir)
+		return nil
+	}
+	return nil
+}
+
+// startInCgroup makes the command start inside the cgroup f.
+func startInCgroup(attr *syscall.SysProcAttr, f *os.File) {
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(f.Fd())
+}
+
+// removeCgroup kills the processes left in a session's cgroup, such as
+// background jobs that outlived the shell, and removes it.
+func removeCgroup(dir string) error {
+	if err := writeCgroupFile(dir, "cgroup.kill", "1"); err != nil {
+		// cgroup.kill needs Linux 5.14; signal the processes one by
+		// one on older kernels.
+		procs, _ := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		for _, field := range strings.Fields(string(procs)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	}
+
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := os.Remove(dir)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// cgroupUsage reads the resource usage of the cgroup dir.
+func cgroupUsage(dir string) ResourceUsage {
+	var u ResourceUsage
+	u.Memory = readCgroupInt(dir, "memory.current")
+	u.MemoryPeak = readCgroupInt(dir, "memory.peak")
+	u.Pids = readCgroupInt(dir, "pids.current")
+	u.CPU = time.Duration(readCgroupKey(dir, "cpu.stat", "usage_usec")) * time.Microsecond
+	u.OOMKills = readCgroupKey(dir, "memory.events", "oom_kill")
+	return u
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// readCgroupInt reads a file holding a single number, or 0.
+func readCgroupInt(dir, name string) int64 {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	return n
+}
+
+// readCgroupKey reads the value of key from a flat keyed file such as
+// cpu.stat, or 0.
+func readCgroupKey(dir, name, key string) int64 {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), " ")
+		if ok && k == key {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
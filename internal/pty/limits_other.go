@@ -0,0 +1,35 @@
+//go:build !linux
+
+package pty
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// errNoLimits is returned when resource limits are configured on a system
+// where they are not supported.
+var errNoLimits = NewError(CodeInvalidArgument, "session resource limits are only supported on Linux")
+
+func rlimitCommand(cmd *exec.Cmd, limits Rlimits) error {
+	return errNoLimits
+}
+
+// RunHelper returns at once: resource limits are not supported on this
+// system.
+func RunHelper() {}
+
+func createCgroup(id string, policy CgroupPolicy) (string, *os.File, error) {
+	return "", nil, errNoLimits
+}
+
+func startInCgroup(attr *syscall.SysProcAttr, f *os.File) {}
+
+func removeCgroup(dir string) error {
+	return nil
+}
+
+func cgroupUsage(dir string) ResourceUsage {
+	return ResourceUsage{}
+}
@@ -0,0 +1,214 @@
+package pty
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogPolicy controls which logs are written for a session, their size and
+// how long they are kept.
+type LogPolicy struct {
+	// Raw writes the session output as is to <id>.log.
+	Raw bool
+	// Plain writes the session output with escape sequences stripped and
+	// line edits applied to <id>.txt, one timestamped line at a time.
+	Plain bool
+
+	// MaxSize is the size in bytes at which a session log is rotated.
+	// Zero disables rotation.
+	MaxSize int64
+	// MaxSegments is the number of rotated segments kept per session in
+	// addition to the active log. Older segments are deleted, so a session
+	// never uses more than MaxSize*(MaxSegments+1) bytes of log.
+	MaxSegments int
+	// Compress gzips rotated segments.
+	Compress bool
+
+	// FlushInterval is the longest time output stays buffered in memory.
+	FlushInterval time.Duration
+	// FlushSize is the amount of buffered output that triggers an early
+	// flush.
+	FlushSize int
+	// BufferLimit is the most output buffered while the disk is behind.
+	BufferLimit int
+	// Durability selects when flushed output is synced to disk.
+	Durability Durability
+
+	// MaxAge is how long the logs of ended sessions are kept after their
+	// last write. Zero keeps them forever.
+	MaxAge time.Duration
+	// MaxSessions is the number of ended sessions whose logs are kept,
+	// newest first. Zero means no limit.
+	MaxSessions int
+	// MaxTotalSize caps the total size in bytes of the log directory. The
+	// logs of the oldest ended sessions are deleted until it fits. Zero
+	// means no limit.
+	MaxTotalSize int64
+	// JanitorInterval is how often retention is enforced.
+	JanitorInterval time.Duration
+}
+
+// DefaultLogPolicy is the policy applied to newly spawned sessions and by the
+// log janitor. It is set from the configuration at startup.
+var DefaultLogPolicy = LogPolicy{
+	Raw:             true,
+	JanitorInterval: 10 * time.Minute,
+}
+
+// logFile is a session log that rotates itself according to a LogPolicy.
+// Rotated segments are named <path>.1 (newest) to <path>.N, with a .gz
+// suffix when compressed.
+type logFile struct {
+	mu     sync.Mutex
+	path   string
+	policy LogPolicy
+	file   *os.File
+	size   int64
+}
+
+func openLogFile(path string, policy LogPolicy) (*logFile, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &logFile{path: path, policy: policy, file: f, size: info.Size()}, nil
+}
+
+// Write appends p to the log, rotating first if p would push the active
+// file past the size cap.
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if l.policy.MaxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.policy.MaxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("[PTY] Warning: failed to rotate log %s: %v", l.path, err)
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Sync commits the active file to stable storage.
+func (l *logFile) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+	return l.file.Sync()
+}
+
+// Close closes the active file.
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// rotate shifts the rotated segments up by one, moves the active file to
+// segment 1 and starts a new active file.
+func (l *logFile) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	ext := ""
+	if l.policy.Compress {
+		ext = ".gz"
+	}
+	// Shift the segments up and drop the one that falls off the end.
+	for i := l.policy.MaxSegments; i >= 1; i-- {
+		os.Rename(l.segment(i)+ext, l.segment(i+1)+ext)
+	}
+	os.Remove(l.segment(l.policy.MaxSegments+1) + ext)
+
+	if l.policy.MaxSegments > 0 {
+		if err := os.Rename(l.path, l.segment(1)); err != nil {
+			return l.reopen(err)
+		}
+		if l.policy.Compress {
+			if err := compressFile(l.segment(1)); err != nil {
+				log.Printf("[PTY] Warning: failed to compress log segment %s: %v", l.segment(1), err)
+			}
+		}
+	} else if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return l.reopen(err)
+	}
+
+	return l.reopen(nil)
+}
+
+// reopen opens a fresh active file, returning cause if that succeeds.
+func (l *logFile) reopen(cause error) error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return cause
+}
+
+func (l *logFile) segment(n int) string {
+	return l.path + "." + strconv.Itoa(n)
+}
+
+// compressFile replaces path with a gzip-compressed path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
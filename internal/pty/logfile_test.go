@@ -0,0 +1,123 @@
+package pty
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogFileRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy LogPolicy
+		writes []string
+		// want maps the suffixes of the files that must exist to their
+		// contents. No other files may exist.
+		want map[string]string
+	}{
+		{
+			name:   "no rotation",
+			writes: []string{"aaaa", "bbbb", "cccc"},
+			want:   map[string]string{"": "aaaabbbbcccc"},
+		},
+		{
+			name:   "fits",
+			policy: LogPolicy{MaxSize: 8, MaxSegments: 2},
+			writes: []string{"aaaa", "bbbb"},
+			want:   map[string]string{"": "aaaabbbb"},
+		},
+		{
+			name:   "oversized first write",
+			policy: LogPolicy{MaxSize: 4, MaxSegments: 2},
+			writes: []string{"0123456789"},
+			want:   map[string]string{"": "0123456789"},
+		},
+		{
+			name:   "segments shift",
+			policy: LogPolicy{MaxSize: 8, MaxSegments: 2},
+			writes: []string{"11111", "22222", "33333"},
+			want:   map[string]string{"": "33333", ".1": "22222", ".2": "11111"},
+		},
+		{
+			name:   "oldest segment dropped",
+			policy: LogPolicy{MaxSize: 8, MaxSegments: 2},
+			writes: []string{"11111", "22222", "33333", "44444"},
+			want:   map[string]string{"": "44444", ".1": "33333", ".2": "22222"},
+		},
+		{
+			name:   "no segments",
+			policy: LogPolicy{MaxSize: 8},
+			writes: []string{"11111", "22222", "33333"},
+			want:   map[string]string{"": "33333"},
+		},
+		{
+			name:   "compressed",
+			policy: LogPolicy{MaxSize: 8, MaxSegments: 2, Compress: true},
+			writes: []string{"11111", "22222", "33333", "44444"},
+			want:   map[string]string{"": "44444", ".1.gz": "33333", ".2.gz": "22222"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "session.log")
+			l, err := openLogFile(path, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.writes {
+				if _, err := l.Write([]byte(w)); err != nil {
+					t.Fatalf("Write(%q): %v", w, err)
+				}
+			}
+			if err := l.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != len(tt.want) {
+				var names []string
+				for _, e := range entries {
+					names = append(names, e.Name())
+				}
+				t.Errorf("files = %v, want %d", names, len(tt.want))
+			}
+			for suffix, want := range tt.want {
+				if got := readLogFile(t, path+suffix); got != want {
+					t.Errorf("%s = %q, want %q", filepath.Base(path+suffix), got, want)
+				}
+			}
+		})
+	}
+}
+
+// readLogFile returns the contents of a log segment, decompressing it if
+// it is gzipped.
+func readLogFile(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		r = zr
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,136 @@
+package pty
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// OpenLog returns a reader over the on-disk log of the session with the
+// given ID, running or not: the raw log, or the plain text log if plain is
+// set. The rotated segments still kept are included, oldest first and
+// decompressed. It returns an error satisfying os.IsNotExist if there is no
+// such log.
+func OpenLog(id string, plain bool) (io.ReadCloser, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+	dir, err := logDirectory()
+	if err != nil {
+		return nil, err
+	}
+	name := id + ".log"
+	if plain {
+		name = id + ".txt"
+	}
+	segments := logSegments(filepath.Join(dir, name))
+	if len(segments) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &segmentReader{segments: segments}, nil
+}
+
+// segmentReader reads log segments one after another, opening each only
+// when the previous one is exhausted.
+type segmentReader struct {
+	segments []string
+	cur      io.ReadCloser
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.segments) == 0 {
+				return 0, io.EOF
+			}
+			f, err := openSegment(r.segments[0])
+			r.segments = r.segments[1:]
+			if os.IsNotExist(err) {
+				// Rotated away since the log was opened.
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+			r.cur = f
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *segmentReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
+
+// logSegments returns the kept files of the log at path, oldest first: the
+// rotated segments from the highest number down, then the active file.
+func logSegments(path string) []string {
+	rotated, _ := filepath.Glob(path + ".*")
+	type segment struct {
+		path string
+		n    int
+	}
+	var segments []segment
+	for _, p := range rotated {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(p, path+"."), ".gz")
+		if n, err := strconv.Atoi(suffix); err == nil {
+			segments = append(segments, segment{path: p, n: n})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].n > segments[j].n })
+
+	paths := make([]string, 0, len(segments)+1)
+	for _, seg := range segments {
+		paths = append(paths, seg.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// gzipFile closes both the decompressor and the underlying file.
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// openSegment opens a log segment, decompressing it if it is gzipped.
+func openSegment(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{Reader: zr, f: f}, nil
+}
@@ -0,0 +1,181 @@
+package pty
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Durability selects when buffered log data is committed to stable storage.
+type Durability string
+
+const (
+	// DurabilityNone never calls fsync; the kernel writes data back on its
+	// own schedule.
+	DurabilityNone Durability = "none"
+	// DurabilityClose calls fsync once when the log is closed.
+	DurabilityClose Durability = "close"
+	// DurabilityFlush calls fsync after every flush.
+	DurabilityFlush Durability = "flush"
+)
+
+// Defaults for the buffering settings of a LogPolicy.
+const (
+	DefaultFlushInterval = time.Second
+	DefaultFlushSize     = 64 * 1024
+	DefaultBufferLimit   = 8 * 1024 * 1024
+)
+
+// LogWriter buffers session output in memory and persists it from a
+// background goroutine, so that writers never wait for the disk. Data is
+// flushed every FlushInterval, or sooner once FlushSize bytes are pending.
+// If the disk falls more than BufferLimit bytes behind, further output is
+// dropped and a marker noting the loss is written to the log instead.
+type LogWriter struct {
+	mu      sync.Mutex
+	buf     []byte
+	spare   []byte
+	dropped int64
+	closed  bool
+
+	file   *logFile
+	policy LogPolicy
+	kick   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewLogWriter opens the log at path for appending and starts its writer
+// goroutine. Rotation, buffering and durability follow policy.
+func NewLogWriter(path string, policy LogPolicy) (*LogWriter, error) {
+	f, err := openLogFile(path, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.FlushInterval <= 0 {
+		policy.FlushInterval = DefaultFlushInterval
+	}
+	if policy.FlushSize <= 0 {
+		policy.FlushSize = DefaultFlushSize
+	}
+	if policy.BufferLimit <= 0 {
+		policy.BufferLimit = DefaultBufferLimit
+	}
+	if policy.Durability == "" {
+		policy.Durability = DurabilityFlush
+	}
+
+	w := &LogWriter{
+		file:   f,
+		policy: policy,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write queues p for writing. It never blocks on disk I/O and always reports
+// success unless the writer is closed.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	// Once output is being dropped, keep dropping until the next flush so
+	// that the log never silently reorders output around the gap.
+	if w.dropped > 0 || len(w.buf)+len(p) > w.policy.BufferLimit {
+		w.dropped += int64(len(p))
+		return len(p), nil
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.policy.FlushSize {
+		select {
+		case w.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Close flushes pending data, syncs it unless the durability mode is none,
+// and closes the log.
+func (w *LogWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		<-w.done
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+
+	var err error
+	if w.policy.Durability != DurabilityNone {
+		err = w.file.Sync()
+	}
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *LogWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.policy.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			w.flush()
+			return
+		case <-w.kick:
+			w.flush()
+		case <-ticker.C:
+			w.flush()
+		}
+	}
+}
+
+// flush writes the pending data. The buffers are swapped under the lock so
+// that writers only ever wait for a memory copy.
+func (w *LogWriter) flush() {
+	w.mu.Lock()
+	data := w.buf
+	dropped := w.dropped
+	w.buf = w.spare[:0]
+	w.dropped = 0
+	w.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("[PTY] Warning: log %s fell behind, dropped %d bytes", w.file.path, dropped)
+	}
+	if len(data) > 0 {
+		if _, err := w.file.Write(data); err != nil {
+			log.Printf("[PTY] Log write error for %s: %v", w.file.path, err)
+		}
+	}
+	if dropped > 0 {
+		marker := fmt.Sprintf("\r\n[webpty: %d bytes of output dropped from log]\r\n", dropped)
+		w.file.Write([]byte(marker))
+	}
+	if (len(data) > 0 || dropped > 0) && w.policy.Durability == DurabilityFlush {
+		if err := w.file.Sync(); err != nil {
+			log.Printf("[PTY] Log sync error for %s: %v", w.file.path, err)
+		}
+	}
+
+	w.mu.Lock()
+	w.spare = data
+	w.mu.Unlock()
+}
@@ -0,0 +1,71 @@
+package pty
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogWriter(t *testing.T) {
+	tests := []struct {
+		name        string
+		bufferLimit int
+		writes      []string
+		want        string
+	}{
+		{
+			name:        "buffered",
+			bufferLimit: 64,
+			writes:      []string{"abcd", "efgh"},
+			want:        "abcdefgh",
+		},
+		{
+			name:        "fills the buffer",
+			bufferLimit: 8,
+			writes:      []string{"abcd", "efgh"},
+			want:        "abcdefgh",
+		},
+		{
+			name:        "drops behind",
+			bufferLimit: 8,
+			writes:      []string{"abcd", "efg", "hi", "j"},
+			want:        "abcdefg\r\n[webpty: 3 bytes of output dropped from log]\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "session.log")
+			// Nothing is flushed before Close, so the buffer limit is
+			// reached deterministically.
+			w, err := NewLogWriter(path, LogPolicy{
+				FlushInterval: time.Hour,
+				FlushSize:     1 << 20,
+				BufferLimit:   tt.bufferLimit,
+				Durability:    DurabilityNone,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range tt.writes {
+				if n, err := w.Write([]byte(p)); n != len(p) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", p, n, err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte("x")); err != os.ErrClosed {
+				t.Errorf("Write after Close = %v, want %v", err, os.ErrClosed)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("log = %q, want %q", data, tt.want)
+			}
+		})
+	}
+}
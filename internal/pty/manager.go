@@ -27,11 +27,27 @@ func (m *Manager) Get(id string) *Session {
 	return m.sessions[id]
 }
 
-// Remove removes a session from the manager.
-func (m *Manager) Remove(id string) {
+// Lookup retrieves a running session by ID. It fails with ErrSessionClosed
+// if the session has ended, as far as the log directory remembers, and with
+// ErrSessionNotFound otherwise.
+func (m *Manager) Lookup(id string) (*Session, error) {
+	if sess := m.Get(id); sess != nil {
+		return sess, nil
+	}
+	if hasMeta(id) {
+		return nil, ErrSessionClosed
+	}
+	return nil, ErrSessionNotFound
+}
+
+// Remove removes a session from the manager. It reports whether the session
+// was present.
+func (m *Manager) Remove(id string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	_, ok := m.sessions[id]
 	delete(m.sessions, id)
+	return ok
 }
 
 // List returns all active sessions.
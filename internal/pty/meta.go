@@ -0,0 +1,108 @@
+package pty
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sessionMeta describes a session. It is stored as <id>.json in the log
+// directory so that the logs of a session can still be attributed to the
+// user that spawned it after it has ended.
+type sessionMeta struct {
+	ID        string            `json:"id"`
+	Owner     int               `json:"owner"`
+	User      string            `json:"user,omitempty"`
+	Shell     string            `json:"shell"`
+	StartedAt time.Time         `json:"started_at"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+func writeMeta(dir string, meta sessionMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, meta.ID+".json"), append(data, '\n'), 0644)
+}
+
+// hasMeta reports whether the log directory holds metadata for the session
+// with the given ID.
+func hasMeta(id string) bool {
+	if _, err := uuid.Parse(id); err != nil {
+		return false
+	}
+	dir, err := logDirectory()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, id+".json"))
+	return err == nil
+}
+
+// LogOwner returns the UID of the user that spawned the session with the
+// given ID, running or not. Logs left without metadata are attributed to the
+// user the server runs as.
+func LogOwner(id string) int {
+	if sess := DefaultManager.Get(id); sess != nil {
+		return sess.Owner
+	}
+	dir, err := logDirectory()
+	if err != nil {
+		return os.Getuid()
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return os.Getuid()
+	}
+	var meta sessionMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return os.Getuid()
+	}
+	return meta.Owner
+}
+
+// LoggedSessions returns the IDs of the sessions that have files in the log
+// directory, running or not, in no particular order.
+func LoggedSessions() ([]string, error) {
+	dir, err := logDirectory()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, entry := range entries {
+		id, _, _ := strings.Cut(entry.Name(), ".")
+		if seen[id] {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// logDirectory returns the directory holding session logs and recordings.
+func logDirectory() (string, error) {
+	dir, err := expandPath("~/.webpty/log")
+	if err != nil {
+		return "", fmt.Errorf("failed to expand log directory: %w", err)
+	}
+	return dir, nil
+}
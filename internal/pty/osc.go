@@ -0,0 +1,90 @@
+package pty
+
+import (
+	"net/url"
+	"os"
+	"time"
+)
+
+// OSC codes handled by sessions.
+const (
+	oscIconAndTitle = 0
+	oscTitle        = 2
+	oscCwd          = 7
+)
+
+// bellInterval is the shortest time between two bell events of a session,
+// so that a program ringing the bell in a loop does not flood subscribers.
+const bellInterval = 100 * time.Millisecond
+
+// Title returns the window title last set by the session's programs.
+func (s *Session) Title() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.title
+}
+
+// handleOSCLocked receives the operating system commands found in the
+// session's output. s.mu must be held.
+func (s *Session) handleOSCLocked(code int, text string) {
+	switch code {
+	case oscIconAndTitle, oscTitle:
+		if text != s.title {
+			s.title = text
+			s.publish(Event{Type: EventTitle, Title: text})
+		}
+	case oscCwd:
+		s.oscCwd = parseCwdReport(text)
+	case oscShellIntegration:
+		s.handleMarkLocked(text, s.scanner.Offset())
+	}
+}
+
+// Cwd returns the session's current working directory: the directory last
+// reported by the shell with OSC 7, or else the working directory of the
+// terminal's foreground process group. It returns "" if neither is known.
+func (s *Session) Cwd() string {
+	s.mu.Lock()
+	cwd := s.oscCwd
+	f := s.Pty
+	s.mu.Unlock()
+	if cwd != "" || f == nil {
+		return cwd
+	}
+	pgid, err := foregroundProcessGroup(f)
+	if err != nil {
+		return ""
+	}
+	cwd, err = processCwd(pgid)
+	if err != nil {
+		return ""
+	}
+	return cwd
+}
+
+// parseCwdReport returns the directory of an OSC 7 report of the form
+// file://host/path. Reports of directories on other hosts, as sent by
+// shells on the far end of ssh, and malformed reports yield "".
+func parseCwdReport(text string) string {
+	u, err := url.Parse(text)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return ""
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		if hostname, err := os.Hostname(); err != nil || u.Host != hostname {
+			return ""
+		}
+	}
+	return u.Path
+}
+
+// bellLocked receives the bells found in the session's output. s.mu must be
+// held.
+func (s *Session) bellLocked() {
+	now := time.Now()
+	if now.Sub(s.lastBell) < bellInterval {
+		return
+	}
+	s.lastBell = now
+	s.publish(Event{Type: EventBell})
+}
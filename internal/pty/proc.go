@@ -0,0 +1,160 @@
+package pty
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Process describes a process running in a session.
+type Process struct {
+	PID  int
+	PPID int
+	PGID int
+	SID  int
+	// Name is the executable name and Args the command line, which is
+	// empty for kernel threads and zombies.
+	Name  string
+	Args  []string
+	State string
+	// CPUTime is the user and system time the process has used.
+	CPUTime   time.Duration
+	StartedAt time.Time
+	// RSS is the resident set size in bytes.
+	RSS int64
+	// Foreground is set for the members of the terminal's foreground
+	// process group.
+	Foreground bool
+}
+
+// CommandLine returns the arguments joined with spaces, or the name in
+// brackets for a process without a command line, as ps shows it.
+func (p Process) CommandLine() string {
+	if len(p.Args) == 0 {
+		return "[" + p.Name + "]"
+	}
+	return strings.Join(p.Args, " ")
+}
+
+// Processes returns the processes of the session: the shell, its
+// descendants, and processes that left the tree but still belong to the
+// session the shell leads. They are in depth-first order starting from the
+// shell, children ordered by PID.
+func (s *Session) Processes() ([]Process, error) {
+	if s.Cmd == nil || s.Cmd.Process == nil {
+		return nil, ErrSessionClosed
+	}
+	shell := s.Cmd.Process.Pid
+
+	all, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+	byPID := make(map[int]Process, len(all))
+	children := make(map[int][]int)
+	for _, p := range all {
+		byPID[p.PID] = p
+		children[p.PPID] = append(children[p.PPID], p.PID)
+	}
+	if _, ok := byPID[shell]; !ok {
+		return nil, ErrSessionClosed
+	}
+
+	fg := -1
+	if s.Pty != nil {
+		if pgid, err := foregroundProcessGroup(s.Pty); err == nil {
+			fg = pgid
+		}
+	}
+
+	var procs []Process
+	seen := make(map[int]bool)
+	var walk func(pid int)
+	walk = func(pid int) {
+		if seen[pid] {
+			return
+		}
+		seen[pid] = true
+		p := byPID[pid]
+		p.Foreground = p.PGID == fg
+		procs = append(procs, p)
+		kids := children[pid]
+		sort.Ints(kids)
+		for _, kid := range kids {
+			walk(kid)
+		}
+	}
+	walk(shell)
+
+	// Processes reparented away from the shell, such as background jobs
+	// of a subshell that exited, are still in its session.
+	var orphans []int
+	for _, p := range all {
+		if p.SID == shell && !seen[p.PID] {
+			orphans = append(orphans, p.PID)
+		}
+	}
+	sort.Ints(orphans)
+	for _, pid := range orphans {
+		walk(pid)
+	}
+	return procs, nil
+}
+
+// ForegroundJob returns the processes of the terminal's foreground process
+// group when that is not the shell's own group, that is, while the shell
+// runs a job in the foreground. It returns nil while the shell waits at its
+// prompt, and when the foreground cannot be determined, such as on systems
+// without /proc.
+func (s *Session) ForegroundJob() []Process {
+	if s.Pty == nil || s.Cmd == nil || s.Cmd.Process == nil {
+		return nil
+	}
+	// The shell leads its own session and process group.
+	pgid, err := foregroundProcessGroup(s.Pty)
+	if err != nil || pgid == s.Cmd.Process.Pid {
+		return nil
+	}
+	procs, err := s.Processes()
+	if err != nil {
+		return nil
+	}
+	var job []Process
+	for _, p := range procs {
+		if p.Foreground {
+			job = append(job, p)
+		}
+	}
+	return job
+}
+
+// CheckIdle returns a BUSY error naming the foreground job if the session
+// is running one, and nil otherwise.
+func (s *Session) CheckIdle() error {
+	job := s.ForegroundJob()
+	if len(job) == 0 {
+		return nil
+	}
+	// Report the group leader, which is the first command of a pipeline,
+	// unless it has already exited.
+	leader := job[0]
+	for _, p := range job {
+		if p.PID == p.PGID {
+			leader = p
+			break
+		}
+	}
+	procs := make([]map[string]interface{}, 0, len(job))
+	for _, p := range job {
+		procs = append(procs, map[string]interface{}{"pid": p.PID, "command": p.CommandLine()})
+	}
+	return &Error{
+		Code:    CodeBusy,
+		Message: "session is busy running " + leader.CommandLine(),
+		Details: map[string]interface{}{
+			"pgid":      leader.PGID,
+			"command":   leader.CommandLine(),
+			"processes": procs,
+		},
+	}
+}
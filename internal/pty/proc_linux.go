@@ -0,0 +1,130 @@
+//go:build linux
+
+package pty
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// clockTicks is the unit of the times in /proc/<pid>/stat, USER_HZ, which
+// is 100 on every Linux architecture.
+const clockTicks = 100
+
+// foregroundProcessGroup returns the ID of the foreground process group of
+// the terminal whose master is f.
+func foregroundProcessGroup(f *os.File) (int, error) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var pgid int32
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgid)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgid), nil
+}
+
+// processCwd returns the working directory of the process with the given
+// PID.
+func processCwd(pid int) (string, error) {
+	return os.Readlink("/proc/" + strconv.Itoa(pid) + "/cwd")
+}
+
+// listProcesses returns every process in /proc. Processes that exit while
+// they are being read are skipped.
+func listProcesses() ([]Process, error) {
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []Process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		p, err := readProcess(pid, boot)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// readProcess reads the process with the given PID from /proc.
+func readProcess(pid int, boot time.Time) (Process, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	stat, err := os.ReadFile(dir + "/stat")
+	if err != nil {
+		return Process{}, err
+	}
+	// The name is in parentheses and may itself contain spaces and
+	// parentheses, so the fields start after the last one.
+	open := bytes.IndexByte(stat, '(')
+	end := bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return Process{}, fmt.Errorf("malformed %s/stat", dir)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	// fields[0] is field 3 of proc(5), the state.
+	if len(fields) < 22 {
+		return Process{}, fmt.Errorf("malformed %s/stat", dir)
+	}
+	field := func(n int) int64 {
+		v, _ := strconv.ParseInt(fields[n-3], 10, 64)
+		return v
+	}
+
+	p := Process{
+		PID:       pid,
+		PPID:      int(field(4)),
+		PGID:      int(field(5)),
+		SID:       int(field(6)),
+		Name:      string(stat[open+1 : end]),
+		State:     fields[0],
+		CPUTime:   time.Duration(field(14)+field(15)) * time.Second / clockTicks,
+		StartedAt: boot.Add(time.Duration(field(22)) * time.Second / clockTicks),
+		RSS:       field(24) * int64(os.Getpagesize()),
+	}
+	if cmdline, err := os.ReadFile(dir + "/cmdline"); err == nil && len(cmdline) > 0 {
+		p.Args = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	return p, nil
+}
+
+// bootTime returns when the system booted, from /proc/stat.
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("btime not found in /proc/stat")
+}
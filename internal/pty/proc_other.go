@@ -0,0 +1,22 @@
+//go:build !linux
+
+package pty
+
+import (
+	"os"
+)
+
+// errNoProc is returned where process information would come from /proc.
+var errNoProc = NewError(CodeInvalidArgument, "process information is only available on Linux")
+
+func foregroundProcessGroup(f *os.File) (int, error) {
+	return 0, errNoProc
+}
+
+func processCwd(pid int) (string, error) {
+	return "", errNoProc
+}
+
+func listProcesses() ([]Process, error) {
+	return nil, errNoProc
+}
@@ -0,0 +1,128 @@
+package pty
+
+import "time"
+
+// DefaultScrollbackSize is the number of bytes of recent output retained per
+// session for screen restoration on attach.
+const DefaultScrollbackSize = 256 * 1024
+
+// Timestamps of writes closer together than stampResolution share one
+// entry, and at most maxStamps entries are kept per buffer.
+const (
+	stampResolution = 100 * time.Millisecond
+	maxStamps       = 4096
+)
+
+// Scrollback is a fixed-size ring buffer of recent PTY output. Bytes are
+// addressed by absolute offsets counted from the start of the session, so a
+// client can resume from the last offset it received. It also remembers
+// roughly when each retained byte was written. It is not safe for concurrent
+// use; callers hold the owning session's lock.
+type Scrollback struct {
+	buf    []byte
+	start  int64
+	end    int64
+	stamps []stamp
+}
+
+// stamp records that the output from offset on was written at time.
+type stamp struct {
+	offset int64
+	time   time.Time
+}
+
+// NewScrollback creates a scrollback buffer holding up to size bytes.
+func NewScrollback(size int) *Scrollback {
+	if size <= 0 {
+		size = DefaultScrollbackSize
+	}
+	return &Scrollback{buf: make([]byte, size)}
+}
+
+// Write appends p to the buffer, discarding the oldest bytes once full.
+func (b *Scrollback) Write(p []byte) {
+	size := int64(len(b.buf))
+	b.end += int64(len(p))
+	if int64(len(p)) > size {
+		p = p[int64(len(p))-size:]
+	}
+	pos := int((b.end - int64(len(p))) % size)
+	n := copy(b.buf[pos:], p)
+	copy(b.buf, p[n:])
+	if b.end-b.start > size {
+		b.start = b.end - size
+	}
+	b.stamp(b.end-int64(len(p)), time.Now())
+}
+
+// stamp records the time of a write at offset and forgets the times of
+// output that is no longer retained.
+func (b *Scrollback) stamp(offset int64, now time.Time) {
+	if n := len(b.stamps); n == 0 || now.Sub(b.stamps[n-1].time) >= stampResolution {
+		b.stamps = append(b.stamps, stamp{offset: offset, time: now})
+	}
+	drop := 0
+	for drop+1 < len(b.stamps) && b.stamps[drop+1].offset <= b.start {
+		drop++
+	}
+	if drop > 0 {
+		b.stamps = append(b.stamps[:0], b.stamps[drop:]...)
+	}
+	// Halve the resolution rather than grow without bound when output
+	// trickles in slowly.
+	if len(b.stamps) > maxStamps {
+		kept := b.stamps[:1]
+		for i := 2; i < len(b.stamps); i += 2 {
+			kept = append(kept, b.stamps[i])
+		}
+		b.stamps = kept
+	}
+}
+
+// times returns a copy of the write times of the retained output, oldest
+// first, for use with timeAt.
+func (b *Scrollback) times() []stamp {
+	return append([]stamp(nil), b.stamps...)
+}
+
+// timeAt returns when the byte at offset was written, according to stamps,
+// or the zero time if it is not covered.
+func timeAt(stamps []stamp, offset int64) time.Time {
+	var t time.Time
+	for _, st := range stamps {
+		if st.offset > offset {
+			break
+		}
+		t = st.time
+	}
+	return t
+}
+
+// Offset returns the absolute offset one past the newest byte written.
+func (b *Scrollback) Offset() int64 {
+	return b.end
+}
+
+// Start returns the absolute offset of the oldest byte still retained.
+func (b *Scrollback) Start() int64 {
+	return b.start
+}
+
+// Since returns a copy of the retained output from offset on, together with
+// the offset of its first byte. If offset has already been discarded the
+// result starts at the oldest retained byte instead; a negative offset
+// requests everything retained.
+func (b *Scrollback) Since(offset int64) ([]byte, int64) {
+	if offset < b.start {
+		offset = b.start
+	}
+	if offset > b.end {
+		offset = b.end
+	}
+	size := int64(len(b.buf))
+	out := make([]byte, b.end-offset)
+	pos := int(offset % size)
+	n := copy(out, b.buf[pos:])
+	copy(out[n:], b.buf)
+	return out, offset
+}
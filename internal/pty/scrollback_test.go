@@ -0,0 +1,47 @@
+package pty
+
+import "testing"
+
+func TestScrollback(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		writes    []string
+		since     int64
+		want      string
+		wantStart int64
+	}{
+		{"empty", 8, nil, -1, "", 0},
+		{"everything", 8, []string{"abc", "def"}, -1, "abcdef", 0},
+		{"from offset", 8, []string{"abc", "def"}, 2, "cdef", 2},
+		{"at end", 8, []string{"abc"}, 3, "", 3},
+		{"past end", 8, []string{"abc"}, 10, "", 3},
+		{"exactly full", 8, []string{"abcd", "efgh"}, -1, "abcdefgh", 0},
+		{"wrapped", 8, []string{"abcde", "fghij"}, -1, "cdefghij", 2},
+		{"wrapped from offset", 8, []string{"abcde", "fghij"}, 6, "ghij", 6},
+		{"wrapped across the end", 8, []string{"abcdef", "ghij"}, 5, "fghij", 5},
+		{"evicted offset", 8, []string{"abcde", "fghij"}, 1, "cdefghij", 2},
+		{"write larger than buffer", 4, []string{"ab", "cdefghij"}, -1, "ghij", 6},
+		{"many small writes", 3, []string{"a", "b", "c", "d", "e"}, 0, "cde", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewScrollback(tt.size)
+			var total int64
+			for _, w := range tt.writes {
+				b.Write([]byte(w))
+				total += int64(len(w))
+			}
+			got, start := b.Since(tt.since)
+			if string(got) != tt.want || start != tt.wantStart {
+				t.Errorf("Since(%d) = %q, %d; want %q, %d", tt.since, got, start, tt.want, tt.wantStart)
+			}
+			if b.Offset() != total {
+				t.Errorf("Offset() = %d, want %d", b.Offset(), total)
+			}
+			if oldest := max(total-int64(tt.size), 0); b.Start() != oldest {
+				t.Errorf("Start() = %d, want %d", b.Start(), oldest)
+			}
+		})
+	}
+}
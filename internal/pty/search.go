@@ -0,0 +1,181 @@
+package pty
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+)
+
+// Sources of search matches.
+const (
+	SourceScrollback = "scrollback"
+	SourceLog        = "log"
+)
+
+// Match is a line of session output that matched a search.
+type Match struct {
+	ID     string
+	Source string
+	// Offset is the byte offset of the start of the line: in the session's
+	// output for scrollback matches, and in the concatenation of the kept
+	// log segments for log matches.
+	Offset int64
+	// Time is when the line was completed, or the zero time if unknown.
+	Time time.Time
+	Line string
+}
+
+// lineScanner strips escape sequences from terminal output and reports each
+// resulting line with the offset of its first byte and the offset just past
+// the byte that completed it.
+type lineScanner struct {
+	san       *vt.Sanitizer
+	start     int64
+	lineStart int64
+}
+
+func newLineScanner(start int64, emit func(line string, start, end int64)) *lineScanner {
+	l := &lineScanner{start: start, lineStart: start}
+	l.san = vt.NewSanitizer(func(line string) {
+		end := l.start + l.san.Written()
+		emit(line, l.lineStart, end)
+		l.lineStart = end
+	})
+	return l
+}
+
+func (l *lineScanner) Write(p []byte) {
+	l.san.Write(p)
+}
+
+func (l *lineScanner) Flush() {
+	l.san.Flush()
+}
+
+// SearchScrollback returns up to limit lines of the session's scrollback
+// that match re, oldest first. Escape sequences are stripped before
+// matching.
+func (s *Session) SearchScrollback(re *regexp.Regexp, limit int) []Match {
+	s.mu.Lock()
+	data, start := s.scrollback.Since(-1)
+	stamps := s.scrollback.times()
+	s.mu.Unlock()
+
+	var matches []Match
+	l := newLineScanner(start, func(line string, start, end int64) {
+		if len(matches) < limit && re.MatchString(line) {
+			matches = append(matches, Match{
+				ID:     s.ID,
+				Source: SourceScrollback,
+				Offset: start,
+				Time:   timeAt(stamps, end-1),
+				Line:   line,
+			})
+		}
+	})
+	l.Write(data)
+	l.Flush()
+	return matches
+}
+
+// SearchLogs returns up to limit lines of the on-disk logs of the session
+// with the given ID that match re, oldest first, including the rotated
+// segments still kept. The plain text log is searched if the session has
+// one, with the times it records. Otherwise the raw log is searched with
+// escape sequences stripped, and the times of its lines are unknown.
+func SearchLogs(id string, re *regexp.Regexp, limit int) ([]Match, error) {
+	dir, err := logDirectory()
+	if err != nil {
+		return nil, err
+	}
+	if segments := logSegments(filepath.Join(dir, id+".txt")); len(segments) > 0 {
+		return searchPlainLog(id, segments, re, limit)
+	}
+	return searchRawLog(id, logSegments(filepath.Join(dir, id+".log")), re, limit)
+}
+
+func searchRawLog(id string, segments []string, re *regexp.Regexp, limit int) ([]Match, error) {
+	var matches []Match
+	l := newLineScanner(0, func(line string, start, end int64) {
+		if len(matches) < limit && re.MatchString(line) {
+			matches = append(matches, Match{ID: id, Source: SourceLog, Offset: start, Line: line})
+		}
+	})
+
+	buf := make([]byte, 32*1024)
+	for _, path := range segments {
+		r, err := openSegment(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Rotated away while searching.
+				continue
+			}
+			return matches, err
+		}
+		for len(matches) < limit {
+			n, err := r.Read(buf)
+			l.Write(buf[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return matches, err
+			}
+		}
+		r.Close()
+		if len(matches) >= limit {
+			return matches, nil
+		}
+	}
+	l.Flush()
+	return matches, nil
+}
+
+func searchPlainLog(id string, segments []string, re *regexp.Regexp, limit int) ([]Match, error) {
+	var matches []Match
+	var offset int64
+	for _, path := range segments {
+		r, err := openSegment(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return matches, err
+		}
+		br := bufio.NewReader(r)
+		for len(matches) < limit {
+			text, err := br.ReadString('\n')
+			start := offset
+			offset += int64(len(text))
+			text = strings.TrimSuffix(text, "\n")
+
+			var t time.Time
+			line := text
+			if stamp, rest, ok := strings.Cut(text, " "); ok {
+				if parsed, perr := time.Parse(plainLogTimeFormat, stamp); perr == nil {
+					t, line = parsed, rest
+				}
+			}
+			if text != "" && re.MatchString(line) {
+				matches = append(matches, Match{ID: id, Source: SourceLog, Offset: start, Time: t, Line: line})
+			}
+
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return matches, err
+			}
+		}
+		r.Close()
+	}
+	return matches, nil
+}
@@ -0,0 +1,61 @@
+package pty
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineScanner(t *testing.T) {
+	type line struct {
+		text       string
+		start, end int64
+	}
+	tests := []struct {
+		name   string
+		start  int64
+		chunks []string
+		want   []line
+	}{
+		{
+			name:   "one chunk",
+			chunks: []string{"ab\r\ncd\r\n"},
+			want:   []line{{"ab", 0, 4}, {"cd", 4, 8}},
+		},
+		{
+			name:   "split lines",
+			chunks: []string{"a", "b\r", "\ncd", "\r\n"},
+			want:   []line{{"ab", 0, 4}, {"cd", 4, 8}},
+		},
+		{
+			name:   "escapes",
+			chunks: []string{"\x1b[1mab\x1b[0m\n", "c\x1b[K\n"},
+			want:   []line{{"ab", 0, 11}, {"c", 11, 16}},
+		},
+		{
+			name:   "start offset",
+			start:  100,
+			chunks: []string{"ab\ncd\n"},
+			want:   []line{{"ab", 100, 103}, {"cd", 103, 106}},
+		},
+		{
+			name:   "flushed",
+			chunks: []string{"ab\ncd"},
+			want:   []line{{"ab", 0, 3}, {"cd", 3, 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []line
+			l := newLineScanner(tt.start, func(text string, start, end int64) {
+				got = append(got, line{text, start, end})
+			})
+			for _, c := range tt.chunks {
+				l.Write([]byte(c))
+			}
+			l.Flush()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lines = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,42 +1,259 @@
 package pty
 
 import (
+	"errors"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/PiranhaCodes/webpty-pty/internal/asciicast"
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
 	ptylib "github.com/creack/pty"
 )
 
+// SessionState describes whether any client is attached to a session.
+type SessionState string
+
+const (
+	// StateAttached means at least one client is receiving live output.
+	StateAttached SessionState = "attached"
+	// StateDetached means the session is running with no client attached.
+	StateDetached SessionState = "detached"
+)
+
+// SessionPolicy holds the settings that apply to every new session.
+type SessionPolicy struct {
+	// IdleWarning is how long a session may go without input or output
+	// before EventIdle is published. Zero disables idle warnings.
+	IdleWarning time.Duration
+	// Rlimits are set on the process of every session.
+	Rlimits Rlimits
+	// Cgroup places every session in a cgroup of its own.
+	Cgroup CgroupPolicy
+	// RunAs lists the users that users may spawn sessions as.
+	RunAs RunAsPolicy
+}
+
+// DefaultSessionPolicy is the policy applied to new sessions.
+var DefaultSessionPolicy SessionPolicy
+
+// plainLogTimeFormat is the format of the timestamp prefixed to every line
+// of a plain text log.
+const plainLogTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
 // Session represents an active PTY session with its associated resources.
 type Session struct {
 	ID         string
+	Owner      int
+	User       string
+	Labels     map[string]string
 	Cmd        *exec.Cmd
 	Pty        *os.File
-	logFile    *os.File
+	logFile    *LogWriter
 	fifoPath   string
 	fifoWriter *os.File
+	scrollback *Scrollback
+	screen     *vt.Terminal
+	clients    map[string]*Client
+	watches    map[string]*Watch
+	// responses queues the responses of watches; see queueResponseLocked.
+	responses  chan watchResponse
+	detachedAt time.Time
+	closed     bool
 	mu         sync.Mutex
+	writeMu    sync.Mutex
 	done       chan struct{}
+	exited     chan struct{}
+
+	plainLog  *LogWriter
+	sanitizer *vt.Sanitizer
+
+	recordFile  *os.File
+	recorder    *asciicast.Writer
+	recordInput bool
+
+	// ShellIntegration is set if the shell was started with the shell
+	// integration loaded.
+	ShellIntegration bool
+
+	idleWarning time.Duration
+	idleTimer   *time.Timer
+
+	// scanner finds the title and other OSC reports in the output.
+	scanner  *vt.Scanner
+	title    string
+	oscCwd   string
+	lastBell time.Time
+
+	// inputStart is the offset of the command line being typed, or -1, and
+	// running the command being run.
+	inputStart int64
+	running    *Command
+	history    []Command
+
+	// cgroup is the directory of the session's cgroup, if it has one.
+	cgroup string
+}
+
+// Authorized reports whether the user with the given UID may attach to the
+// session. Root and the user that spawned the session are authorized.
+func (s *Session) Authorized(uid int) bool {
+	return uid == 0 || uid == s.Owner
+}
+
+// Attach registers a new client for the session's live output. It returns
+// the client, the data the caller replays to restore the screen before
+// consuming live output, and the offset at which live output starts. When
+// since is non-negative the restore data is the retained output from that
+// offset on. Otherwise it repaints the emulated screen if the session has a
+// terminal emulator, or replays the whole scrollback buffer if not. No
+// output is lost or duplicated between the restore data and the live stream.
+func (s *Session) Attach(uid, pid int, since int64) (*Client, []byte, int64, error) {
+	if !s.Authorized(uid) {
+		return nil, nil, 0, ErrPermissionDenied
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, nil, 0, ErrSessionClosed
+	}
+
+	c := newClient(s, uid, pid)
+	var restore []byte
+	if since < 0 && s.screen != nil {
+		restore = []byte(s.screen.Snapshot().ANSI())
+	} else {
+		restore, _ = s.scrollback.Since(since)
+	}
+	s.clients[c.ID] = c
+	log.Printf("[PTY] Session %s: client %s attached (uid %d)", s.ID, c.ID, uid)
+	s.publish(Event{Type: EventAttached, ClientID: c.ID})
+	return c, restore, s.scrollback.Offset(), nil
+}
+
+// Detach detaches the client with the given ID. It reports whether the
+// client was attached.
+func (s *Session) Detach(clientID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[clientID]
+	if !ok {
+		return false
+	}
+	s.detachLocked(c, ErrDetached)
+	return true
+}
+
+func (s *Session) detachLocked(c *Client, reason error) {
+	delete(s.clients, c.ID)
+	c.close(reason)
+	if len(s.clients) == 0 {
+		s.detachedAt = time.Now()
+	}
+	log.Printf("[PTY] Session %s: client %s detached: %v", s.ID, c.ID, reason)
+	s.publish(Event{Type: EventDetached, ClientID: c.ID, Reason: reason.Error()})
+}
+
+// closeClients detaches every client and ends every watch because the
+// session is ending.
+func (s *Session) closeClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+	}
+	for _, c := range s.clients {
+		s.detachLocked(c, ErrSessionClosed)
+	}
+	for _, w := range s.watches {
+		s.endWatchLocked(w, ErrSessionClosed)
+	}
+}
+
+// Snapshot returns the current screen of the session's terminal emulator.
+func (s *Session) Snapshot() (*vt.Snapshot, error) {
+	if s.screen == nil {
+		return nil, ErrNoEmulator
+	}
+	return s.screen.Snapshot(), nil
+}
+
+// State returns whether the session currently has attached clients.
+func (s *Session) State() SessionState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) > 0 {
+		return StateAttached
+	}
+	return StateDetached
+}
+
+// DetachedAt returns when the last client detached, or the zero time if the
+// session is attached or no client has ever detached.
+func (s *Session) DetachedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) > 0 {
+		return time.Time{}
+	}
+	return s.detachedAt
+}
+
+// Clients returns information about the currently attached clients.
+func (s *Session) Clients() []ClientInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	infos := make([]ClientInfo, 0, len(s.clients))
+	for _, c := range s.clients {
+		infos = append(infos, ClientInfo{
+			ID:         c.ID,
+			UID:        c.UID,
+			PID:        c.PID,
+			AttachedAt: c.AttachedAt,
+		})
+	}
+	return infos
 }
 
 // Write sends data to the PTY stdin.
 func (s *Session) Write(data []byte) (int, error) {
+	// Writes are ordered by writeMu rather than s.mu, so that a program
+	// that does not read its input blocks its writers but not ReadLoop.
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if s.Pty == nil {
-		return 0, io.ErrClosedPipe
+	if s.Pty == nil || s.hasExited() {
+		s.mu.Unlock()
+		return 0, ErrSessionClosed
+	}
+	s.touchLocked()
+	s.mu.Unlock()
+
+	n, err := s.Pty.Write(data)
+	if n > 0 && s.recorder != nil && s.recordInput {
+		if err := s.recorder.Input(data[:n]); err != nil {
+			log.Printf("[PTY] Session %s: Recording write error: %v", s.ID, err)
+		}
 	}
-	return s.Pty.Write(data)
+	return n, err
 }
 
-// ReadLoop continuously reads from PTY and writes output to both FIFO and log file.
+// ReadLoop continuously reads from PTY and writes output to the scrollback
+// buffer, attached clients, pattern watches, the FIFO, the log files and the
+// recording, and tracks the window title, working directory and commands.
 // It runs until the PTY is closed and then triggers cleanup.
 func (s *Session) ReadLoop() {
 	defer func() {
+		if s.sanitizer != nil {
+			s.sanitizer.Flush()
+		}
 		close(s.done)
 		CleanupSession(s)
 	}()
@@ -61,6 +278,22 @@ func (s *Session) ReadLoop() {
 		copy(data, buf[:n])
 
 		s.mu.Lock()
+		s.touchLocked()
+		offset := s.scrollback.Offset()
+		s.scrollback.Write(data)
+		if s.screen != nil {
+			s.screen.Write(data)
+		}
+		s.scanner.Write(data)
+		for _, c := range s.clients {
+			select {
+			case c.output <- Output{Offset: offset, Data: data}:
+			default:
+				s.detachLocked(c, ErrClientTooSlow)
+			}
+		}
+		s.feedWatchesLocked(data, offset+int64(n))
+
 		if s.fifoWriter != nil {
 			go func(d []byte) {
 				if _, err := s.fifoWriter.Write(d); err != nil {
@@ -87,9 +320,80 @@ func (s *Session) ReadLoop() {
 			if _, err := s.logFile.Write(data); err != nil {
 				log.Printf("[PTY] Session %s: Log write error: %v", s.ID, err)
 			}
-			s.logFile.Sync()
 		}
+
+		if s.sanitizer != nil {
+			s.sanitizer.Write(data)
+		}
+
+		if s.recorder != nil {
+			if err := s.recorder.Output(data); err != nil {
+				log.Printf("[PTY] Session %s: Recording write error: %v", s.ID, err)
+			}
+		}
+	}
+}
+
+// touchLocked restarts the idle warning timer on input or output. s.mu must
+// be held.
+func (s *Session) touchLocked() {
+	if s.idleTimer != nil && !s.closed {
+		s.idleTimer.Reset(s.idleWarning)
+	}
+}
+
+// idle publishes EventIdle once the idle warning timer expires.
+func (s *Session) idle() {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed {
+		s.publish(Event{Type: EventIdle, Idle: s.idleWarning})
+	}
+}
+
+// writePlainLine writes a sanitized line to the plain log, prefixed with the
+// time it was completed.
+func (s *Session) writePlainLine(line string) {
+	stamp := time.Now().UTC().Format(plainLogTimeFormat)
+	if _, err := s.plainLog.Write([]byte(stamp + " " + line + "\n")); err != nil && !errors.Is(err, os.ErrClosed) {
+		log.Printf("[PTY] Session %s: Plain log write error: %v", s.ID, err)
+	}
+}
+
+// Exited returns a channel that is closed once the session's process has
+// exited and been reaped.
+func (s *Session) Exited() <-chan struct{} {
+	return s.exited
+}
+
+// hasExited reports whether the session's process has exited.
+func (s *Session) hasExited() bool {
+	select {
+	case <-s.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExitStatus returns the exit code of the session's process, or -1 and the
+// name of the signal that terminated it. ok is false while the process is
+// still running.
+func (s *Session) ExitStatus() (code int, signal string, ok bool) {
+	select {
+	case <-s.exited:
+	default:
+		return 0, "", false
+	}
+	state := s.Cmd.ProcessState
+	if state == nil {
+		return -1, "", true
 	}
+	if ws, isWait := state.Sys().(syscall.WaitStatus); isWait && ws.Signaled() {
+		return -1, ws.Signal().String(), true
+	}
+	return state.ExitCode(), "", true
 }
 
 // Wait blocks until the session completes.
@@ -97,15 +401,46 @@ func (s *Session) Wait() {
 	<-s.done
 }
 
+// CheckSize returns an INVALID_ARGUMENT error if a terminal size exceeds
+// vt.MaxCols by vt.MaxRows. Larger sizes would not fit the PTY's 16-bit
+// window size, and the emulator would allocate memory for every cell.
+func CheckSize(cols, rows int) error {
+	if cols > vt.MaxCols {
+		return InvalidField("cols", "cols must not exceed %d", vt.MaxCols)
+	}
+	if rows > vt.MaxRows {
+		return InvalidField("rows", "rows must not exceed %d", vt.MaxRows)
+	}
+	return nil
+}
+
 // Resize resizes the PTY terminal to the specified dimensions.
 func (s *Session) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return NewError(CodeInvalidArgument, "cols and rows must be positive")
+	}
+	if err := CheckSize(cols, rows); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.Pty == nil {
-		return io.ErrClosedPipe
+	if s.Pty == nil || s.hasExited() {
+		return ErrSessionClosed
 	}
-	return ptylib.Setsize(s.Pty, &ptylib.Winsize{
+	if err := ptylib.Setsize(s.Pty, &ptylib.Winsize{
 		Rows: uint16(rows),
 		Cols: uint16(cols),
-	})
+	}); err != nil {
+		return err
+	}
+	if s.screen != nil {
+		s.screen.Resize(cols, rows)
+	}
+	if s.recorder != nil {
+		if err := s.recorder.Resize(cols, rows); err != nil {
+			log.Printf("[PTY] Session %s: Recording write error: %v", s.ID, err)
+		}
+	}
+	s.publish(Event{Type: EventResized, Cols: cols, Rows: rows})
+	return nil
 }
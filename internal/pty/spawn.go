@@ -3,12 +3,17 @@ package pty
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/PiranhaCodes/webpty-pty/internal/asciicast"
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
 	ptylib "github.com/creack/pty"
 	"github.com/google/uuid"
 )
@@ -35,19 +40,132 @@ func expandPath(path string) (string, error) {
 	return path, nil
 }
 
-// SpawnShell creates a new PTY session with an auto-detected shell.
-// It creates the FIFO pipe and log file, and starts the read loop.
-func SpawnShell() (*Session, error) {
-	shellPath, err := DetectShell()
+// SpawnOptions configures a new session.
+type SpawnOptions struct {
+	// Owner is the UID of the user the session belongs to. Only the owner
+	// and root may attach to it.
+	Owner int
+	// Cols and Rows set the initial terminal size. Non-positive values
+	// default to 80x24; the size must not exceed vt.MaxCols by vt.MaxRows.
+	Cols int
+	Rows int
+	// Emulator feeds the session's output through a terminal emulator so
+	// that screen snapshots are available.
+	Emulator bool
+	// Record writes an asciicast v2 recording of the session next to its
+	// log file. RecordInput additionally records what is typed into it.
+	Record      bool
+	RecordInput bool
+	// Command runs the given program and arguments instead of the shell.
+	Command []string
+	// ScrollbackSize is the number of bytes of recent output retained in
+	// memory. Non-positive values use DefaultScrollbackSize.
+	ScrollbackSize int
+	// Labels are attached to the session and its events.
+	Labels map[string]string
+	// Dir is the working directory of the session's process. Empty uses
+	// the server's.
+	Dir string
+	// User, if set, is the name or UID of the user the session runs as,
+	// with that user's login shell unless Command is set. Empty runs the
+	// session as the server's user or, if the server runs as root, as
+	// Owner. Owner must be allowed to run sessions as the user by
+	// DefaultSessionPolicy.
+	User string
+	// ShellIntegration starts bash and zsh with startup files that mark
+	// prompts and commands, so that the session's command history is
+	// recorded. It has no effect on other shells or with Command.
+	ShellIntegration bool
+}
+
+// SpawnShell creates a new PTY session with an auto-detected shell, or with
+// opts.Command if set. It creates the FIFO pipe and log file, and starts the
+// read loop.
+func SpawnShell(opts SpawnOptions) (*Session, error) {
+	if opts.Cols <= 0 {
+		opts.Cols = vt.DefaultCols
+	}
+	if opts.Rows <= 0 {
+		opts.Rows = vt.DefaultRows
+	}
+	if err := CheckSize(opts.Cols, opts.Rows); err != nil {
+		return nil, err
+	}
+
+	as, err := sessionRunAs(opts.Owner, opts.User)
 	if err != nil {
-		return nil, fmt.Errorf("shell detection failed: %w", err)
+		return nil, err
+	}
+
+	var shellPath string
+	var cmd *exec.Cmd
+	if len(opts.Command) > 0 {
+		cmd = exec.Command(opts.Command[0], opts.Command[1:]...)
+		shellPath = cmd.Path
+	} else if as != nil {
+		shellPath = as.shell
+		cmd = exec.Command(shellPath)
+	} else {
+		shellPath, err = DetectShell()
+		if err != nil {
+			return nil, fmt.Errorf("shell detection failed: %w", err)
+		}
+		cmd = exec.Command(shellPath)
 	}
 
 	id := uuid.New().String()
-	cmd := exec.Command(shellPath)
 	cmd.Env = os.Environ()
+	cmd.Dir = opts.Dir
+	if as != nil {
+		cmd.Env = as.env(cmd.Env)
+		if cmd.Dir == "" {
+			cmd.Dir = as.user.HomeDir
+		}
+	}
 
-	ptyFile, err := ptylib.Start(cmd)
+	integration := false
+	if opts.ShellIntegration && len(opts.Command) == 0 {
+		integration, err = addIntegration(cmd, shellPath, as != nil && as.cred != nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to install shell integration: %w", err)
+		}
+	}
+
+	// The cgroup is removed again, killing the process, if the session
+	// fails to start.
+	var cgroup string
+	spawned := false
+	defer func() {
+		if cgroup != "" && !spawned {
+			if err := removeCgroup(cgroup); err != nil {
+				log.Printf("[PTY] Warning: failed to remove cgroup %s: %v", cgroup, err)
+			}
+		}
+	}()
+	if DefaultSessionPolicy.Cgroup.Parent != "" {
+		dir, f, err := createCgroup(id, DefaultSessionPolicy.Cgroup)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		cgroup = dir
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+		startInCgroup(cmd.SysProcAttr, f)
+	}
+
+	if !DefaultSessionPolicy.Rlimits.empty() {
+		if err := rlimitCommand(cmd, DefaultSessionPolicy.Rlimits); err != nil {
+			return nil, err
+		}
+	}
+
+	size := &ptylib.Winsize{Cols: uint16(opts.Cols), Rows: uint16(opts.Rows)}
+	var ptyFile *os.File
+	if as != nil && as.cred != nil {
+		ptyFile, err = startAs(cmd, size, as)
+	} else {
+		ptyFile, err = ptylib.StartWithSize(cmd, size)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to start PTY: %w", err)
 	}
@@ -81,6 +199,16 @@ func SpawnShell() (*Session, error) {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
+	var userName string
+	if as != nil {
+		userName = as.user.Username
+	}
+
+	meta := sessionMeta{ID: id, Owner: opts.Owner, User: userName, Shell: shellPath, StartedAt: time.Now().UTC(), Labels: opts.Labels}
+	if err := writeMeta(logDir, meta); err != nil {
+		log.Printf("[PTY] Warning: failed to write metadata for session %s: %v", id, err)
+	}
+
 	fifoPath := filepath.Join(sessionsDir, id+".out")
 	if err := os.Remove(fifoPath); err != nil && !os.IsNotExist(err) {
 		ptyFile.Close()
@@ -106,35 +234,173 @@ func SpawnShell() (*Session, error) {
 		fifoWriter = nil
 	}
 
-	logPath := filepath.Join(logDir, id+".log")
-	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		fifoWriter.Close()
-		os.Remove(fifoPath)
-		ptyFile.Close()
-		cmd.Process.Kill()
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+	var logFile *LogWriter
+	if DefaultLogPolicy.Raw {
+		logFile, err = NewLogWriter(filepath.Join(logDir, id+".log"), DefaultLogPolicy)
+		if err != nil {
+			fifoWriter.Close()
+			os.Remove(fifoPath)
+			ptyFile.Close()
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+	}
+
+	var plainLog *LogWriter
+	if DefaultLogPolicy.Plain {
+		plainLog, err = NewLogWriter(filepath.Join(logDir, id+".txt"), DefaultLogPolicy)
+		if err != nil {
+			if logFile != nil {
+				logFile.Close()
+			}
+			fifoWriter.Close()
+			os.Remove(fifoPath)
+			ptyFile.Close()
+			cmd.Process.Kill()
+			return nil, fmt.Errorf("failed to open plain log file: %w", err)
+		}
+	}
+
+	var recordFile *os.File
+	var recorder *asciicast.Writer
+	if opts.Record {
+		recordFile, recorder, err = startRecording(filepath.Join(logDir, id+".cast"), shellPath, cmd.Env, opts)
+		if err != nil {
+			if logFile != nil {
+				logFile.Close()
+			}
+			if plainLog != nil {
+				plainLog.Close()
+			}
+			fifoWriter.Close()
+			os.Remove(fifoPath)
+			ptyFile.Close()
+			cmd.Process.Kill()
+			return nil, err
+		}
 	}
 
 	sess := &Session{
 		ID:         id,
+		Owner:      opts.Owner,
+		User:       userName,
+		Labels:     opts.Labels,
 		Cmd:        cmd,
 		Pty:        ptyFile,
 		logFile:    logFile,
 		fifoPath:   fifoPath,
 		fifoWriter: fifoWriter,
+		scrollback: NewScrollback(opts.ScrollbackSize),
+		clients:    make(map[string]*Client),
+		watches:    make(map[string]*Watch),
 		done:       make(chan struct{}),
+		exited:     make(chan struct{}),
+
+		recordFile:  recordFile,
+		recorder:    recorder,
+		recordInput: opts.RecordInput,
+
+		ShellIntegration: integration,
+
+		cgroup: cgroup,
+
+		idleWarning: DefaultSessionPolicy.IdleWarning,
+		inputStart:  -1,
+	}
+	sess.scanner = vt.NewScanner(sess.handleOSCLocked, sess.bellLocked)
+	if opts.Emulator {
+		sess.screen = vt.New(opts.Cols, opts.Rows)
+	}
+	if plainLog != nil {
+		sess.plainLog = plainLog
+		sess.sanitizer = vt.NewSanitizer(sess.writePlainLine)
 	}
 
+	if sess.idleWarning > 0 {
+		sess.idleTimer = time.AfterFunc(sess.idleWarning, sess.idle)
+	}
+
+	spawned = true
 	DefaultManager.Add(id, sess)
+	sess.publish(Event{Type: EventSpawned, Cols: opts.Cols, Rows: opts.Rows})
 	go sess.ReadLoop()
 
 	go func() {
 		if err := cmd.Wait(); err != nil {
 			log.Printf("[PTY] Session %s: process exited with error: %v", id, err)
 		}
+		close(sess.exited)
 	}()
 
-	log.Printf("[PTY] Spawned session %s with shell %s", id, shellPath)
+	log.Printf("[PTY] Spawned session %s with command %s", id, shellPath)
 	return sess, nil
 }
+
+// RecordingPath returns the path of the asciicast recording of the session
+// with the given ID. The session need not be running anymore.
+func RecordingPath(id string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", &Error{Code: CodeInvalidArgument, Message: "invalid session ID", Details: map[string]interface{}{"field": "id"}, Err: err}
+	}
+	logDir, err := logDirectory()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(logDir, id+".cast"), nil
+}
+
+// startRecording creates the asciicast file for a session and writes its
+// header, carrying the terminal size, shell and terminal type.
+func startRecording(path, shellPath string, env []string, opts SpawnOptions) (*os.File, *asciicast.Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open recording file: %w", err)
+	}
+
+	header := asciicast.Header{
+		Width:   opts.Cols,
+		Height:  opts.Rows,
+		Command: shellPath,
+		Env:     map[string]string{"SHELL": shellPath},
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "TERM=") {
+			header.Env["TERM"] = strings.TrimPrefix(kv, "TERM=")
+		}
+	}
+
+	var out io.Writer = f
+	if DefaultLogPolicy.MaxSize > 0 {
+		out = &recordingLimit{w: f, path: path, left: DefaultLogPolicy.MaxSize * int64(1+DefaultLogPolicy.MaxSegments)}
+	}
+	w, err := asciicast.NewWriter(out, header)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, nil, err
+	}
+	return f, w, nil
+}
+
+// recordingLimit caps the size of a recording at what a log may take up
+// with its rotated segments. A recording cannot be rotated, so it ends at
+// the last event that fits.
+type recordingLimit struct {
+	w    io.Writer
+	path string
+	left int64
+	full bool
+}
+
+func (l *recordingLimit) Write(p []byte) (int, error) {
+	if l.full {
+		return len(p), nil
+	}
+	if int64(len(p)) > l.left {
+		l.full = true
+		log.Printf("[PTY] Warning: recording %s reached its size limit, recording stopped", l.path)
+		return len(p), nil
+	}
+	l.left -= int64(len(p))
+	return l.w.Write(p)
+}
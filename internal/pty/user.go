@@ -0,0 +1,211 @@
+package pty
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	ptylib "github.com/creack/pty"
+)
+
+// RunAsPolicy lists, by the name of the user requesting a session, the users
+// whose sessions it may spawn. The key "*" applies to every user, and the
+// value "*" allows every user except root, which must be listed by name.
+// Root may spawn sessions as anyone, and every user as themself.
+type RunAsPolicy map[string][]string
+
+// allows reports whether the user with UID uid may spawn sessions as target.
+func (p RunAsPolicy) allows(uid int, target *user.User) bool {
+	if uid == 0 || strconv.Itoa(uid) == target.Uid {
+		return true
+	}
+	allowed := p["*"]
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		allowed = append(allowed[:len(allowed):len(allowed)], p[u.Username]...)
+	}
+	for _, name := range allowed {
+		if name == target.Username || (name == "*" && target.Uid != "0") {
+			return true
+		}
+	}
+	return false
+}
+
+// runAs is the identity a session's process is started with.
+type runAs struct {
+	user  *user.User
+	shell string
+	// cred is nil when the server already runs as the user.
+	cred *syscall.Credential
+}
+
+// sessionRunAs resolves the identity a session spawned by the user with UID
+// owner runs as, given the name of the user it asks for, if any. By default
+// a session runs as the server's user, except that a server running as root
+// does not hand its identity to other users and runs their sessions as
+// themselves. It returns nil for a session that runs as the server's user
+// by default.
+func sessionRunAs(owner int, name string) (*runAs, error) {
+	if name == "" && os.Getuid() == 0 && owner != 0 {
+		name = strconv.Itoa(owner)
+	}
+	if name != "" {
+		return lookupRunAs(owner, name)
+	}
+
+	// The server's user is subject to the policy like any other.
+	if owner == 0 || owner == os.Getuid() {
+		return nil, nil
+	}
+	u, err := user.LookupId(strconv.Itoa(os.Getuid()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the server's user: %w", err)
+	}
+	if !DefaultSessionPolicy.RunAs.allows(owner, u) {
+		return nil, errRunAsDenied(u)
+	}
+	return nil, nil
+}
+
+// errRunAsDenied returns the error for a session as u that is not allowed.
+func errRunAsDenied(u *user.User) error {
+	return &Error{
+		Code:    CodePermissionDenied,
+		Message: fmt.Sprintf("not allowed to run sessions as %s", u.Username),
+		Details: map[string]interface{}{"field": "user"},
+	}
+}
+
+// lookupRunAs resolves the user named name, or given by numeric UID, that
+// the user with UID owner wants to spawn a session as, and checks that
+// DefaultSessionPolicy allows it.
+func lookupRunAs(owner int, name string) (*runAs, error) {
+	u, err := user.Lookup(name)
+	if _, numeric := strconv.Atoi(name); err != nil && numeric == nil {
+		u, err = user.LookupId(name)
+	}
+	if err != nil {
+		return nil, InvalidField("user", "unknown user %q", name)
+	}
+
+	if !DefaultSessionPolicy.RunAs.allows(owner, u) {
+		return nil, errRunAsDenied(u)
+	}
+
+	r := &runAs{user: u, shell: passwdShell(u.Username)}
+	if u.Uid == strconv.Itoa(os.Getuid()) {
+		return r, nil
+	}
+	if os.Getuid() != 0 {
+		return nil, NewError(CodePermissionDenied, "the server must run as root to run sessions as other users")
+	}
+
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+	r.cred = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up groups of %s: %w", u.Username, err)
+	}
+	for _, g := range groups {
+		if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+			r.cred.Groups = append(r.cred.Groups, uint32(id))
+		}
+	}
+	return r, nil
+}
+
+// env returns the environment of a session running as the user. Like
+// login, it starts afresh rather than pass on the server's environment,
+// keeping only the terminal type and the locale.
+func (r *runAs) env(environ []string) []string {
+	var env []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == "TERM" || name == "LANG" || strings.HasPrefix(name, "LC_") {
+			env = append(env, kv)
+		}
+	}
+	path := "/usr/local/bin:/usr/bin:/bin"
+	if r.user.Uid == "0" {
+		path = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+	}
+	return append(env,
+		"PATH="+path,
+		"HOME="+r.user.HomeDir,
+		"USER="+r.user.Username,
+		"LOGNAME="+r.user.Username,
+		"SHELL="+r.shell,
+	)
+}
+
+// passwdShell returns the login shell of the named user from /etc/passwd,
+// or /bin/sh if it has none there.
+func passwdShell(name string) string {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return "/bin/sh"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == name && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return "/bin/sh"
+}
+
+// startAs starts cmd on a new PTY as the given user, like
+// ptylib.StartWithSize, after handing the terminal to the user the way
+// login does, so that programs opening it by name, such as tty and mesg,
+// work.
+func startAs(cmd *exec.Cmd, ws *ptylib.Winsize, r *runAs) (*os.File, error) {
+	ptmx, tty, err := ptylib.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+
+	if err := ptylib.Setsize(ptmx, ws); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+
+	uid, _ := strconv.Atoi(r.user.Uid)
+	gid, _ := strconv.Atoi(r.user.Gid)
+	mode := os.FileMode(0600)
+	if g, err := user.LookupGroup("tty"); err == nil {
+		gid, _ = strconv.Atoi(g.Gid)
+		mode = 0620
+	}
+	if err := os.Chown(tty.Name(), uid, gid); err != nil {
+		ptmx.Close()
+		return nil, fmt.Errorf("failed to chown %s: %w", tty.Name(), err)
+	}
+	if err := os.Chmod(tty.Name(), mode); err != nil {
+		ptmx.Close()
+		return nil, fmt.Errorf("failed to chmod %s: %w", tty.Name(), err)
+	}
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Credential = r.cred
+
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
@@ -0,0 +1,318 @@
+package pty
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/vt"
+	"github.com/google/uuid"
+)
+
+const (
+	// watchQueueSize is the number of matches buffered per watch before its
+	// consumer is considered too slow and the watch is ended.
+	watchQueueSize = 64
+	// watchWindow bounds the unmatched text a watch keeps to match against.
+	watchWindow = 32 * 1024
+	// responseQueueSize is the number of responses queued per session while
+	// its program does not read its input. Further responses are dropped.
+	responseQueueSize = 64
+)
+
+var (
+	// ErrWatchMatched ends a watch that stops after its first match.
+	ErrWatchMatched = errors.New("matched")
+	// ErrWatchTimeout ends a watch whose pattern did not match in time.
+	ErrWatchTimeout = errors.New("timeout")
+	// ErrWatchRemoved ends a watch that was removed on request.
+	ErrWatchRemoved = errors.New("removed")
+	// ErrEmptyPattern is returned for a pattern that matches empty text and
+	// would therefore match everywhere.
+	ErrEmptyPattern = InvalidField("pattern", "pattern matches empty text")
+)
+
+// WatchOptions configures a pattern watch.
+type WatchOptions struct {
+	Pattern *regexp.Regexp
+	// Response is written to the session's input every time the pattern
+	// matches.
+	Response []byte
+	// Repeat keeps the watch after a match. Otherwise it ends with its first
+	// match.
+	Repeat bool
+	// Timeout ends the watch once the pattern has not matched for this long.
+	// Zero waits forever.
+	Timeout time.Duration
+	// Since, when non-negative, also matches the retained output from this
+	// offset on, so that a prompt printed before the watch was registered is
+	// not missed.
+	Since int64
+}
+
+// WatchMatch is an occurrence of a watched pattern.
+type WatchMatch struct {
+	// Text is the matched text and Groups the text of its capture groups.
+	Text   string
+	Groups []string
+	// Offset is the offset just past the output chunk that completed the
+	// match.
+	Offset int64
+	Time   time.Time
+}
+
+// Watch is a regular expression matched against a session's output with
+// escape sequences stripped and line edits applied. Matched text is
+// consumed, so every occurrence is reported once. The unterminated last line
+// is matched too, so prompts are seen while they wait for input.
+type Watch struct {
+	ID string
+
+	sess *Session
+	opts WatchOptions
+	san  *vt.Sanitizer
+	// text holds the completed lines not yet consumed by a match, and skip
+	// the length of the pending line already consumed.
+	text string
+	skip int
+
+	timer   *time.Timer
+	matches chan WatchMatch
+	done    chan struct{}
+	err     error
+	once    sync.Once
+}
+
+// Matches returns the channel on which matches are delivered.
+func (w *Watch) Matches() <-chan WatchMatch {
+	return w.matches
+}
+
+// Done returns a channel that is closed once the watch has ended.
+func (w *Watch) Done() <-chan struct{} {
+	return w.done
+}
+
+// Err returns why the watch ended, or nil while it is active.
+func (w *Watch) Err() error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+		return nil
+	}
+}
+
+// Remove ends the watch.
+func (w *Watch) Remove() {
+	w.sess.endWatch(w, ErrWatchRemoved)
+}
+
+// close marks the watch ended with the given reason. The match channel is
+// left open so that matches already queued can still be drained.
+func (w *Watch) close(err error) {
+	w.once.Do(func() {
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		w.err = err
+		close(w.done)
+	})
+}
+
+// Watch registers a pattern watch on the session's output for the user with
+// the given UID, who must be authorized to attach to it.
+func (s *Session) Watch(uid int, opts WatchOptions) (*Watch, error) {
+	if !s.Authorized(uid) {
+		return nil, ErrPermissionDenied
+	}
+	if opts.Pattern.MatchString("") {
+		return nil, ErrEmptyPattern
+	}
+
+	w := &Watch{
+		ID:      uuid.New().String(),
+		sess:    s,
+		opts:    opts,
+		matches: make(chan WatchMatch, watchQueueSize),
+		done:    make(chan struct{}),
+	}
+	w.san = vt.NewSanitizer(w.addLine)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrSessionClosed
+	}
+	if opts.Since >= 0 {
+		data, _ := s.scrollback.Since(opts.Since)
+		s.feedWatchLocked(w, data, s.scrollback.Offset())
+	}
+	if w.Err() == nil {
+		s.watches[w.ID] = w
+		if opts.Timeout > 0 {
+			w.timer = time.AfterFunc(opts.Timeout, w.expire)
+		}
+	}
+	s.mu.Unlock()
+
+	return w, nil
+}
+
+// Unwatch ends the watch with the given ID. It reports whether the watch
+// was active.
+func (s *Session) Unwatch(watchID string) bool {
+	s.mu.Lock()
+	w, ok := s.watches[watchID]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	s.endWatch(w, ErrWatchRemoved)
+	return true
+}
+
+func (s *Session) endWatch(w *Watch, reason error) {
+	s.mu.Lock()
+	s.endWatchLocked(w, reason)
+	s.mu.Unlock()
+}
+
+func (s *Session) endWatchLocked(w *Watch, reason error) {
+	if s.watches[w.ID] == w {
+		delete(s.watches, w.ID)
+	}
+	w.close(reason)
+}
+
+// expire ends the watch unless it matched while the timer was firing.
+func (w *Watch) expire() {
+	s := w.sess
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watches[w.ID] != w {
+		return
+	}
+	s.endWatchLocked(w, ErrWatchTimeout)
+}
+
+// feedWatchesLocked matches a chunk of output ending at offset against every
+// watch.
+func (s *Session) feedWatchesLocked(data []byte, offset int64) {
+	for _, w := range s.watches {
+		s.feedWatchLocked(w, data, offset)
+	}
+}
+
+// feedWatchLocked matches a chunk of output against w, delivers the matches
+// and queues w's response for each.
+func (s *Session) feedWatchLocked(w *Watch, data []byte, offset int64) {
+	w.san.Write(data)
+
+	for w.Err() == nil {
+		pending := w.san.Pending()
+		// Text consumed by a match that has since been redrawn is new.
+		if edited := w.san.Edited(); edited >= 0 && edited < w.skip {
+			w.skip = edited
+		}
+		if w.skip > len(pending) {
+			w.skip = len(pending)
+		}
+		hay := w.text + pending[w.skip:]
+		loc := w.opts.Pattern.FindStringSubmatchIndex(hay)
+		if loc == nil {
+			break
+		}
+
+		m := WatchMatch{Text: hay[loc[0]:loc[1]], Offset: offset, Time: time.Now()}
+		for i := 2; i+1 < len(loc); i += 2 {
+			group := ""
+			if loc[i] >= 0 {
+				group = hay[loc[i]:loc[i+1]]
+			}
+			m.Groups = append(m.Groups, group)
+		}
+		if loc[1] <= len(w.text) {
+			w.text = w.text[loc[1]:]
+		} else {
+			w.skip += loc[1] - len(w.text)
+			w.text = ""
+		}
+
+		select {
+		case w.matches <- m:
+		default:
+			s.endWatchLocked(w, ErrClientTooSlow)
+			return
+		}
+		if len(w.opts.Response) > 0 {
+			s.queueResponseLocked(w)
+		}
+
+		if !w.opts.Repeat {
+			s.endWatchLocked(w, ErrWatchMatched)
+		} else if w.timer != nil {
+			w.timer.Reset(w.opts.Timeout)
+		}
+	}
+}
+
+// addLine receives a completed line from the watch's sanitizer.
+func (w *Watch) addLine(line string) {
+	if w.skip > 0 {
+		if w.skip >= len(line) {
+			line = ""
+		} else {
+			line = line[w.skip:]
+		}
+		w.skip = 0
+	}
+	w.text += line + "\n"
+	if len(w.text) > watchWindow {
+		cut := len(w.text) - watchWindow
+		for cut < len(w.text) && !utf8.RuneStart(w.text[cut]) {
+			cut++
+		}
+		w.text = w.text[cut:]
+	}
+}
+
+// queueResponseLocked queues w's response to be written to the session's
+// input. Responses are written by a goroutine of their own rather than by
+// ReadLoop: a program that does not read its input would block the write,
+// and ReadLoop with it, so that its output would never be read either.
+func (s *Session) queueResponseLocked(w *Watch) {
+	if s.responses == nil {
+		s.responses = make(chan watchResponse, responseQueueSize)
+		go s.writeResponses(s.responses)
+	}
+	select {
+	case s.responses <- watchResponse{watch: w, data: w.opts.Response}:
+	default:
+		log.Printf("[PTY] Session %s: watch %s response dropped, input is not being read", s.ID, w.ID)
+	}
+}
+
+// watchResponse is a response queued by a watch.
+type watchResponse struct {
+	watch *Watch
+	data  []byte
+}
+
+// writeResponses writes queued responses to the session's input until the
+// session has ended.
+func (s *Session) writeResponses(queue <-chan watchResponse) {
+	for {
+		select {
+		case r := <-queue:
+			if _, err := s.Write(r.data); err != nil {
+				log.Printf("[PTY] Session %s: watch %s response failed: %v", s.ID, r.watch.ID, err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package vt
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ColorMode selects how a Color value is interpreted.
+type ColorMode uint8
+
+const (
+	// ColorDefault is the terminal's default foreground or background.
+	ColorDefault ColorMode = iota
+	// ColorPalette is an index into the 256-color palette.
+	ColorPalette
+	// ColorRGB is a 24-bit true color.
+	ColorRGB
+)
+
+// Color is a foreground or background color.
+type Color struct {
+	Mode  ColorMode
+	Value uint32
+}
+
+// String returns "" for the default color, the palette index for palette
+// colors and "#rrggbb" for true colors.
+func (c Color) String() string {
+	switch c.Mode {
+	case ColorPalette:
+		return strconv.Itoa(int(c.Value))
+	case ColorRGB:
+		return fmt.Sprintf("#%06x", c.Value)
+	default:
+		return ""
+	}
+}
+
+// sgr returns the SGR parameters selecting the color; base is 30 for the
+// foreground and 40 for the background.
+func (c Color) sgr(base int) string {
+	switch c.Mode {
+	case ColorPalette:
+		switch {
+		case c.Value < 8:
+			return strconv.Itoa(base + int(c.Value))
+		case c.Value < 16:
+			return strconv.Itoa(base + 60 + int(c.Value) - 8)
+		default:
+			return fmt.Sprintf("%d;5;%d", base+8, c.Value)
+		}
+	case ColorRGB:
+		return fmt.Sprintf("%d;2;%d;%d;%d", base+8, c.Value>>16&0xff, c.Value>>8&0xff, c.Value&0xff)
+	default:
+		return strconv.Itoa(base + 9)
+	}
+}
+
+// Attr is a set of character attributes.
+type Attr uint16
+
+// Character attributes selectable with SGR.
+const (
+	AttrBold Attr = 1 << iota
+	AttrFaint
+	AttrItalic
+	AttrUnderline
+	AttrBlink
+	AttrInverse
+	AttrHidden
+	AttrStrike
+)
+
+// attrSGR lists the SGR parameter that enables each attribute.
+var attrSGR = []struct {
+	attr Attr
+	code int
+}{
+	{AttrBold, 1},
+	{AttrFaint, 2},
+	{AttrItalic, 3},
+	{AttrUnderline, 4},
+	{AttrBlink, 5},
+	{AttrInverse, 7},
+	{AttrHidden, 8},
+	{AttrStrike, 9},
+}
+
+// Style is the rendition applied to a cell.
+type Style struct {
+	FG    Color
+	BG    Color
+	Attrs Attr
+}
+
+// sgr returns a complete SGR sequence that resets the rendition and then
+// selects the style.
+func (s Style) sgr() string {
+	seq := "\x1b[0"
+	for _, a := range attrSGR {
+		if s.Attrs&a.attr != 0 {
+			seq += ";" + strconv.Itoa(a.code)
+		}
+	}
+	if s.FG.Mode != ColorDefault {
+		seq += ";" + s.FG.sgr(30)
+	}
+	if s.BG.Mode != ColorDefault {
+		seq += ";" + s.BG.sgr(40)
+	}
+	return seq + "m"
+}
+
+// Cell is a single character position on the screen. A wide character
+// occupies its own cell with Width 2 followed by a continuation cell with
+// Width 0.
+type Cell struct {
+	Rune  rune
+	Width int
+	Style Style
+}
+
+// blank returns an empty cell that keeps the background of style, matching
+// xterm's background color erase behavior.
+func blank(style Style) Cell {
+	return Cell{Rune: ' ', Width: 1, Style: Style{BG: style.BG}}
+}
+
+// runeWidth returns the number of columns r occupies: 0 for combining marks
+// and other zero-width characters, 2 for East Asian wide characters and
+// emoji, and 1 otherwise.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0x200b || r == 0x200c || r == 0x200d || r == 0xfeff:
+		return 0
+	case r >= 0x0300 && r <= 0x036f, r >= 0x1ab0 && r <= 0x1aff,
+		r >= 0x1dc0 && r <= 0x1dff, r >= 0x20d0 && r <= 0x20ff,
+		r >= 0xfe00 && r <= 0xfe0f, r >= 0xfe20 && r <= 0xfe2f:
+		return 0
+	case r >= 0x1100 && r <= 0x115f, r >= 0x2e80 && r <= 0x303e,
+		r >= 0x3041 && r <= 0x33ff, r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff, r >= 0xa000 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3, r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f, r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6, r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff, r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	default:
+		return 1
+	}
+}
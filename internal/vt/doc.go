@@ -0,0 +1,5 @@
+// Package vt implements a VT100/xterm terminal state machine that tracks the
+// screen grid, cursor, character attributes, alternate screen and scroll
+// region of a PTY output stream, and renders snapshots of the screen as text,
+// cells or an escape sequence stream that repaints it.
+package vt
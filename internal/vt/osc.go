@@ -0,0 +1,140 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// maxOSCLength bounds the length of an operating system command that is
+// reported. Longer ones are discarded.
+const maxOSCLength = 4096
+
+// Scanner reports the operating system commands (OSC) and bells in a
+// terminal output stream, such as the window title set by a shell prompt.
+// It keeps its state across writes, so sequences may be split between
+// chunks of output.
+type Scanner struct {
+	osc  func(code int, text string)
+	bell func()
+
+	state parserState
+	pos   int64
+	// inOSC is set while the control string being read is an OSC, and
+	// overflow once it has grown beyond maxOSCLength.
+	inOSC    bool
+	overflow bool
+	buf      []byte
+}
+
+// NewScanner returns a scanner that calls osc with the numeric code and the
+// text of every OSC, and bell for every BEL outside a control string.
+// Either may be nil.
+func NewScanner(osc func(code int, text string), bell func()) *Scanner {
+	return &Scanner{osc: osc, bell: bell}
+}
+
+// Write feeds terminal output to the scanner. It never fails.
+func (s *Scanner) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.pos++
+		s.feed(b)
+	}
+	return len(p), nil
+}
+
+// Offset returns the number of bytes written to the scanner. Called from
+// the osc or bell function, it is the offset just past the sequence being
+// reported.
+func (s *Scanner) Offset() int64 {
+	return s.pos
+}
+
+func (s *Scanner) feed(b byte) {
+	switch s.state {
+	case stateGround, stateCharset:
+		s.state = stateGround
+		switch b {
+		case 0x1b:
+			s.state = stateEscape
+		case 0x07:
+			s.ring()
+		}
+	case stateEscape:
+		s.state = stateGround
+		switch {
+		case b == '[':
+			s.state = stateCSI
+		case b == ']':
+			s.state = stateString
+			s.inOSC = true
+			s.overflow = false
+			s.buf = s.buf[:0]
+		case b == 'P' || b == 'X' || b == '^' || b == '_':
+			s.state = stateString
+			s.inOSC = false
+		case b == 0x1b:
+			s.state = stateEscape
+		case b == 0x07:
+			s.ring()
+			s.state = stateEscape
+		case b >= 0x20 && b < 0x30:
+			s.state = stateCharset
+		}
+	case stateCSI:
+		switch {
+		case b == 0x1b:
+			s.state = stateEscape
+		case b == 0x07:
+			s.ring()
+		case b >= 0x40 && b < 0x7f:
+			s.state = stateGround
+		}
+	case stateString:
+		switch b {
+		case 0x07:
+			s.state = stateGround
+			s.endString()
+		case 0x1b:
+			s.state = stateStringEscape
+		default:
+			if s.inOSC && !s.overflow {
+				if len(s.buf) < maxOSCLength {
+					s.buf = append(s.buf, b)
+				} else {
+					s.overflow = true
+				}
+			}
+		}
+	case stateStringEscape:
+		// ESC \ terminates the string; any other escape aborts it.
+		s.state = stateGround
+		if b == '\\' {
+			s.endString()
+		} else {
+			s.inOSC = false
+			s.state = stateEscape
+			s.feed(b)
+		}
+	}
+}
+
+func (s *Scanner) ring() {
+	if s.bell != nil {
+		s.bell()
+	}
+}
+
+// endString reports a terminated OSC of the form "code;text" or "code".
+func (s *Scanner) endString() {
+	if !s.inOSC || s.overflow || s.osc == nil {
+		s.inOSC = false
+		return
+	}
+	s.inOSC = false
+	codeText, text, _ := strings.Cut(string(s.buf), ";")
+	code, err := strconv.Atoi(codeText)
+	if err != nil || code < 0 {
+		return
+	}
+	s.osc(code, strings.ToValidUTF8(text, "�"))
+}
@@ -0,0 +1,517 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parserState is a state of the escape sequence parser, loosely following
+// the DEC ANSI parser state diagram.
+type parserState int
+
+const (
+	stateGround parserState = iota
+	stateEscape
+	stateCharset
+	stateCSI
+	stateString
+	stateStringEscape
+)
+
+// maxParams bounds the number of CSI parameters that are collected.
+const maxParams = 32
+
+// maxParamValue bounds the value of a CSI parameter, so that arithmetic on
+// cursor positions cannot overflow.
+const maxParamValue = 65535
+
+// parser turns a byte stream into terminal operations.
+type parser struct {
+	state parserState
+
+	// pending holds the bytes of an incomplete UTF-8 sequence.
+	pending [utf8.UTFMax]byte
+	npend   int
+
+	params        []byte
+	intermediates []byte
+}
+
+func (p *parser) feed(t *Terminal, b byte) {
+	switch p.state {
+	case stateGround:
+		p.ground(t, b)
+	case stateEscape:
+		p.escape(t, b)
+	case stateCharset:
+		// The designated character set is ignored; output is UTF-8.
+		p.state = stateGround
+	case stateCSI:
+		p.csi(t, b)
+	case stateString:
+		switch b {
+		case 0x07:
+			p.state = stateGround
+		case 0x1b:
+			p.state = stateStringEscape
+		}
+	case stateStringEscape:
+		// ESC \ terminates the string; any other escape aborts it and is
+		// processed normally.
+		p.state = stateGround
+		if b != '\\' {
+			p.escape(t, b)
+		}
+	}
+}
+
+func (p *parser) ground(t *Terminal, b byte) {
+	if p.npend > 0 {
+		if b&0xc0 == 0x80 {
+			p.pending[p.npend] = b
+			p.npend++
+			if utf8.FullRune(p.pending[:p.npend]) {
+				r, _ := utf8.DecodeRune(p.pending[:p.npend])
+				p.npend = 0
+				t.print(r)
+			}
+			return
+		}
+		// Invalid sequence: emit a replacement and reprocess the byte.
+		p.npend = 0
+		t.print(utf8.RuneError)
+	}
+
+	switch {
+	case b == 0x1b:
+		p.state = stateEscape
+		p.intermediates = p.intermediates[:0]
+	case b < 0x20:
+		p.execute(t, b)
+	case b == 0x7f:
+	case b < 0x80:
+		t.print(rune(b))
+	case b >= 0xc0 && b < 0xf8:
+		p.pending[0] = b
+		p.npend = 1
+	default:
+		t.print(utf8.RuneError)
+	}
+}
+
+// execute performs a C0 control function.
+func (p *parser) execute(t *Terminal, b byte) {
+	switch b {
+	case '\b':
+		if t.cur.x > 0 {
+			t.cur.x--
+		}
+		t.cur.wrapPending = false
+	case '\t':
+		x := t.cur.x + 1
+		for x < t.cols-1 && !t.tabs[x] {
+			x++
+		}
+		t.cur.x = clamp(x, 0, t.cols-1)
+	case '\n', '\v', '\f':
+		t.index()
+		t.cur.wrapPending = false
+	case '\r':
+		t.cur.x = 0
+		t.cur.wrapPending = false
+	}
+}
+
+func (p *parser) escape(t *Terminal, b byte) {
+	p.state = stateGround
+	switch b {
+	case '[':
+		p.state = stateCSI
+		p.params = p.params[:0]
+		p.intermediates = p.intermediates[:0]
+	case ']', 'P', 'X', '^', '_':
+		// OSC, DCS, SOS, PM and APC strings do not affect the screen.
+		p.state = stateString
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.index()
+		t.cur.wrapPending = false
+	case 'E':
+		t.index()
+		t.cur.x = 0
+		t.cur.wrapPending = false
+	case 'H':
+		t.tabs[t.cur.x] = true
+	case 'M':
+		t.reverseIndex()
+		t.cur.wrapPending = false
+	case 'c':
+		t.reset()
+	case 0x1b:
+		p.state = stateEscape
+	default:
+		switch {
+		case b < 0x20:
+			p.execute(t, b)
+			p.state = stateEscape
+		case b < 0x30:
+			// Character set designations and other sequences with an
+			// intermediate byte take one more byte that is ignored.
+			p.state = stateCharset
+		}
+	}
+}
+
+func (p *parser) csi(t *Terminal, b byte) {
+	switch {
+	case b == 0x1b:
+		p.state = stateEscape
+	case b < 0x20:
+		p.execute(t, b)
+	case b < 0x30:
+		p.intermediates = append(p.intermediates, b)
+	case b < 0x40:
+		if len(p.params) < 256 {
+			p.params = append(p.params, b)
+		}
+	case b < 0x7f:
+		p.state = stateGround
+		p.dispatchCSI(t, b)
+	}
+}
+
+// csiParams splits the raw parameter bytes into the private marker, if any,
+// and the numeric parameters. Sub-parameters separated by ':' are kept as
+// separate entries in the sub slice of each parameter.
+func (p *parser) csiParams() (private byte, params [][]int) {
+	raw := string(p.params)
+	if raw != "" && raw[0] >= '<' && raw[0] <= '?' {
+		private = raw[0]
+		raw = raw[1:]
+	}
+	if raw == "" {
+		return private, nil
+	}
+	for _, field := range strings.Split(raw, ";") {
+		if len(params) == maxParams {
+			break
+		}
+		var sub []int
+		for _, s := range strings.Split(field, ":") {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				n = 0
+			}
+			sub = append(sub, min(n, maxParamValue))
+		}
+		params = append(params, sub)
+	}
+	return private, params
+}
+
+// param returns parameter i, or def if it is missing or zero.
+func param(params [][]int, i, def int) int {
+	if i < len(params) && params[i][0] != 0 {
+		return params[i][0]
+	}
+	return def
+}
+
+func (p *parser) dispatchCSI(t *Terminal, final byte) {
+	private, params := p.csiParams()
+	if len(p.intermediates) > 0 {
+		// DECSTR (CSI ! p) is the only intermediate sequence that affects
+		// the screen state.
+		if string(p.intermediates) == "!" && final == 'p' {
+			t.softReset()
+		}
+		return
+	}
+
+	if private == '?' {
+		switch final {
+		case 'h', 'l':
+			for _, prm := range params {
+				t.setPrivateMode(prm[0], final == 'h')
+			}
+		}
+		return
+	}
+	if private != 0 {
+		return
+	}
+
+	n := param(params, 0, 1)
+	switch final {
+	case '@':
+		line := t.active().lines[t.cur.y]
+		n = clamp(n, 0, t.cols-t.cur.x)
+		copy(line[t.cur.x+n:], line[t.cur.x:])
+		t.eraseCells(t.cur.y, t.cur.x, t.cur.x+n)
+	case 'A':
+		t.cur.y = clamp(t.cur.y-n, t.marginTop(), t.rows-1)
+		t.cur.wrapPending = false
+	case 'B', 'e':
+		t.cur.y = clamp(t.cur.y+n, 0, t.marginBottom())
+		t.cur.wrapPending = false
+	case 'C', 'a':
+		t.cur.x = clamp(t.cur.x+n, 0, t.cols-1)
+		t.cur.wrapPending = false
+	case 'D':
+		t.cur.x = clamp(t.cur.x-n, 0, t.cols-1)
+		t.cur.wrapPending = false
+	case 'E':
+		t.cur.y = clamp(t.cur.y+n, 0, t.marginBottom())
+		t.cur.x = 0
+		t.cur.wrapPending = false
+	case 'F':
+		t.cur.y = clamp(t.cur.y-n, t.marginTop(), t.rows-1)
+		t.cur.x = 0
+		t.cur.wrapPending = false
+	case 'G', '`':
+		t.cur.x = clamp(n-1, 0, t.cols-1)
+		t.cur.wrapPending = false
+	case 'H', 'f':
+		t.moveTo(param(params, 1, 1)-1, n-1)
+	case 'I':
+		for i := 0; i < n; i++ {
+			p.execute(t, '\t')
+		}
+	case 'J':
+		t.eraseDisplay(param(params, 0, 0))
+	case 'K':
+		switch param(params, 0, 0) {
+		case 0:
+			t.eraseCells(t.cur.y, t.cur.x, t.cols)
+		case 1:
+			t.eraseCells(t.cur.y, 0, t.cur.x+1)
+		case 2:
+			t.eraseCells(t.cur.y, 0, t.cols)
+		}
+	case 'L':
+		if t.cur.y >= t.top && t.cur.y <= t.bottom {
+			t.insertLines(t.cur.y, n)
+			t.cur.x = 0
+		}
+	case 'M':
+		if t.cur.y >= t.top && t.cur.y <= t.bottom {
+			t.deleteLines(t.cur.y, n)
+			t.cur.x = 0
+		}
+	case 'P':
+		line := t.active().lines[t.cur.y]
+		n = clamp(n, 0, t.cols-t.cur.x)
+		copy(line[t.cur.x:], line[t.cur.x+n:])
+		t.eraseCells(t.cur.y, t.cols-n, t.cols)
+	case 'S':
+		t.scrollUp(n)
+	case 'T':
+		t.scrollDown(n)
+	case 'X':
+		t.eraseCells(t.cur.y, t.cur.x, t.cur.x+n)
+	case 'Z':
+		for i := 0; i < n && t.cur.x > 0; i++ {
+			t.cur.x--
+			for t.cur.x > 0 && !t.tabs[t.cur.x] {
+				t.cur.x--
+			}
+		}
+	case 'b':
+		if t.lastRune != 0 {
+			for i := 0; i < n && i < t.cols*t.rows; i++ {
+				t.print(t.lastRune)
+			}
+		}
+	case 'd':
+		t.moveTo(t.cur.x, n-1)
+	case 'g':
+		switch param(params, 0, 0) {
+		case 0:
+			t.tabs[t.cur.x] = false
+		case 3:
+			t.tabs = make([]bool, t.cols)
+		}
+	case 'h', 'l':
+		for _, prm := range params {
+			if prm[0] == 4 {
+				t.insertMode = final == 'h'
+			}
+		}
+	case 'm':
+		t.sgr(params)
+	case 'r':
+		top := param(params, 0, 1) - 1
+		bottom := param(params, 1, t.rows) - 1
+		if top < bottom && bottom < t.rows {
+			t.top, t.bottom = top, bottom
+			t.moveTo(0, 0)
+		}
+	case 's':
+		t.saveCursor()
+	case 'u':
+		t.restoreCursor()
+	}
+}
+
+// marginTop and marginBottom bound vertical cursor movement: the scroll
+// region limits it only while the cursor is inside the region.
+func (t *Terminal) marginTop() int {
+	if t.cur.y >= t.top {
+		return t.top
+	}
+	return 0
+}
+
+func (t *Terminal) marginBottom() int {
+	if t.cur.y <= t.bottom {
+		return t.bottom
+	}
+	return t.rows - 1
+}
+
+func (t *Terminal) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		t.eraseCells(t.cur.y, t.cur.x, t.cols)
+		for y := t.cur.y + 1; y < t.rows; y++ {
+			t.eraseCells(y, 0, t.cols)
+		}
+	case 1:
+		for y := 0; y < t.cur.y; y++ {
+			t.eraseCells(y, 0, t.cols)
+		}
+		t.eraseCells(t.cur.y, 0, t.cur.x+1)
+	case 2:
+		for y := 0; y < t.rows; y++ {
+			t.eraseCells(y, 0, t.cols)
+		}
+	}
+}
+
+// softReset performs DECSTR.
+func (t *Terminal) softReset() {
+	t.cur.style = Style{}
+	t.cur.originMode = false
+	t.cur.wrapPending = false
+	t.top, t.bottom = 0, t.rows-1
+	t.autoWrap = true
+	t.insertMode = false
+	t.cursorVisible = true
+	t.active().saved = cursor{}
+}
+
+// sgr applies a Select Graphic Rendition sequence to the cursor style.
+func (t *Terminal) sgr(params [][]int) {
+	st := &t.cur.style
+	if len(params) == 0 {
+		*st = Style{}
+		return
+	}
+	for i := 0; i < len(params); i++ {
+		prm := params[i]
+		switch n := prm[0]; {
+		case n == 0:
+			*st = Style{}
+		case n == 1:
+			st.Attrs |= AttrBold
+		case n == 2:
+			st.Attrs |= AttrFaint
+		case n == 3:
+			st.Attrs |= AttrItalic
+		case n == 4:
+			if len(prm) > 1 && prm[1] == 0 {
+				st.Attrs &^= AttrUnderline
+			} else {
+				st.Attrs |= AttrUnderline
+			}
+		case n == 5 || n == 6:
+			st.Attrs |= AttrBlink
+		case n == 7:
+			st.Attrs |= AttrInverse
+		case n == 8:
+			st.Attrs |= AttrHidden
+		case n == 9:
+			st.Attrs |= AttrStrike
+		case n == 21:
+			st.Attrs |= AttrUnderline
+		case n == 22:
+			st.Attrs &^= AttrBold | AttrFaint
+		case n == 23:
+			st.Attrs &^= AttrItalic
+		case n == 24:
+			st.Attrs &^= AttrUnderline
+		case n == 25:
+			st.Attrs &^= AttrBlink
+		case n == 27:
+			st.Attrs &^= AttrInverse
+		case n == 28:
+			st.Attrs &^= AttrHidden
+		case n == 29:
+			st.Attrs &^= AttrStrike
+		case n >= 30 && n <= 37:
+			st.FG = Color{Mode: ColorPalette, Value: uint32(n - 30)}
+		case n == 38:
+			st.FG, i = extendedColor(params, i)
+		case n == 39:
+			st.FG = Color{}
+		case n >= 40 && n <= 47:
+			st.BG = Color{Mode: ColorPalette, Value: uint32(n - 40)}
+		case n == 48:
+			st.BG, i = extendedColor(params, i)
+		case n == 49:
+			st.BG = Color{}
+		case n >= 90 && n <= 97:
+			st.FG = Color{Mode: ColorPalette, Value: uint32(n - 90 + 8)}
+		case n >= 100 && n <= 107:
+			st.BG = Color{Mode: ColorPalette, Value: uint32(n - 100 + 8)}
+		}
+	}
+}
+
+// extendedColor parses a 38 or 48 color selection at params[i], in either
+// the colon form (38:5:n, 38:2::r:g:b) or the semicolon form (38;5;n,
+// 38;2;r;g;b). It returns the color and the index of the last parameter
+// consumed.
+func extendedColor(params [][]int, i int) (Color, int) {
+	if sub := params[i]; len(sub) > 1 {
+		switch sub[1] {
+		case 5:
+			if len(sub) > 2 {
+				return Color{Mode: ColorPalette, Value: uint32(sub[2] & 0xff)}, i
+			}
+		case 2:
+			rgb := sub[2:]
+			if len(rgb) > 3 {
+				// Skip the optional color space identifier.
+				rgb = rgb[len(rgb)-3:]
+			}
+			if len(rgb) == 3 {
+				return rgbColor(rgb[0], rgb[1], rgb[2]), i
+			}
+		}
+		return Color{}, i
+	}
+
+	if i+1 >= len(params) {
+		return Color{}, i
+	}
+	switch params[i+1][0] {
+	case 5:
+		if i+2 < len(params) {
+			return Color{Mode: ColorPalette, Value: uint32(params[i+2][0] & 0xff)}, i + 2
+		}
+	case 2:
+		if i+4 < len(params) {
+			return rgbColor(params[i+2][0], params[i+3][0], params[i+4][0]), i + 4
+		}
+	}
+	return Color{}, len(params)
+}
+
+func rgbColor(r, g, b int) Color {
+	return Color{Mode: ColorRGB, Value: uint32(r&0xff)<<16 | uint32(g&0xff)<<8 | uint32(b&0xff)}
+}
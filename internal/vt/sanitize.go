@@ -0,0 +1,270 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength bounds the length of a sanitized line. Longer lines are
+// split.
+const maxLineLength = 64 * 1024
+
+// Sanitizer turns a terminal output stream into plain text lines. Escape
+// sequences and control characters are removed, and the edits they make to
+// the current line are applied the way a terminal would: a carriage return
+// lets the following text overwrite the line, a backspace steps back over
+// it, and erase-in-line truncates it. Cursor movement to other lines is
+// ignored, so full-screen programs produce approximate output.
+type Sanitizer struct {
+	emit func(line string)
+
+	state   parserState
+	pending [utf8.UTFMax]byte
+	npend   int
+	params  []byte
+
+	line []rune
+	col  int
+
+	written int64
+	// edited is the first column of the current line changed since Edited
+	// was last called, or -1.
+	edited int
+}
+
+// NewSanitizer returns a sanitizer that calls emit with every completed
+// line, without the line terminator.
+func NewSanitizer(emit func(line string)) *Sanitizer {
+	return &Sanitizer{emit: emit, edited: -1}
+}
+
+// Write feeds terminal output to the sanitizer. It never fails.
+func (s *Sanitizer) Write(p []byte) (int, error) {
+	for _, b := range p {
+		s.written++
+		s.feed(b)
+	}
+	return len(p), nil
+}
+
+// Written returns the number of bytes fed to the sanitizer so far. Called
+// from the emit function, it is the count up to and including the byte that
+// completed the line.
+func (s *Sanitizer) Written() int64 {
+	return s.written
+}
+
+// Flush emits the current line if it is not empty.
+func (s *Sanitizer) Flush() {
+	if len(s.line) > 0 {
+		s.endLine()
+	}
+}
+
+// Pending returns the current line, which has not been terminated yet.
+// Unlike emitted lines, it keeps trailing blanks, so that a prompt such as
+// "Password: " can be recognized before anything is typed.
+func (s *Sanitizer) Pending() string {
+	return string(s.line)
+}
+
+// Edited returns the byte offset in Pending from which text already written
+// to the current line has been overwritten or erased since the last call, or
+// -1 if none has. Text appended to the line does not count as an edit.
+func (s *Sanitizer) Edited() int {
+	col := s.edited
+	s.edited = -1
+	if col < 0 {
+		return -1
+	}
+	return len(string(s.line[:min(col, len(s.line))]))
+}
+
+// Strip returns p with escape sequences removed and line edits applied, as
+// the lines a Sanitizer emits joined by newlines.
+func Strip(p []byte) string {
+	var lines []string
+	s := NewSanitizer(func(line string) { lines = append(lines, line) })
+	s.Write(p)
+	s.Flush()
+	return strings.Join(lines, "\n")
+}
+
+func (s *Sanitizer) feed(b byte) {
+	switch s.state {
+	case stateGround:
+		s.ground(b)
+	case stateEscape:
+		s.state = stateGround
+		switch {
+		case b == '[':
+			s.state = stateCSI
+			s.params = s.params[:0]
+		case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+			s.state = stateString
+		case b == 0x1b:
+			s.state = stateEscape
+		case b >= 0x20 && b < 0x30:
+			s.state = stateCharset
+		}
+	case stateCharset:
+		s.state = stateGround
+	case stateCSI:
+		switch {
+		case b == 0x1b:
+			s.state = stateEscape
+		case b >= 0x40 && b < 0x7f:
+			s.state = stateGround
+			s.csi(b)
+		case b >= 0x20 && b < 0x40:
+			if len(s.params) < 64 {
+				s.params = append(s.params, b)
+			}
+		}
+	case stateString:
+		switch b {
+		case 0x07:
+			s.state = stateGround
+		case 0x1b:
+			s.state = stateStringEscape
+		}
+	case stateStringEscape:
+		s.state = stateGround
+		if b != '\\' {
+			s.state = stateEscape
+			s.feed(b)
+		}
+	}
+}
+
+func (s *Sanitizer) ground(b byte) {
+	if s.npend > 0 {
+		if b&0xc0 == 0x80 {
+			s.pending[s.npend] = b
+			s.npend++
+			if utf8.FullRune(s.pending[:s.npend]) {
+				r, _ := utf8.DecodeRune(s.pending[:s.npend])
+				s.npend = 0
+				s.put(r)
+			}
+			return
+		}
+		s.npend = 0
+		s.put(utf8.RuneError)
+	}
+
+	switch {
+	case b == 0x1b:
+		s.state = stateEscape
+	case b == '\n':
+		s.endLine()
+	case b == '\r':
+		s.col = 0
+	case b == '\b':
+		if s.col > 0 {
+			s.col--
+		}
+	case b == '\t':
+		s.moveTo((s.col/8 + 1) * 8)
+	case b < 0x20 || b == 0x7f:
+	case b < 0x80:
+		s.put(rune(b))
+	case b >= 0xc0 && b < 0xf8:
+		s.pending[0] = b
+		s.npend = 1
+	default:
+		s.put(utf8.RuneError)
+	}
+}
+
+// csi applies the escape sequences that edit the current line.
+func (s *Sanitizer) csi(final byte) {
+	raw := string(s.params)
+	if raw != "" && raw[0] >= '<' && raw[0] <= '?' {
+		return
+	}
+	n := 1
+	if first, _, _ := strings.Cut(raw, ";"); first != "" {
+		if v, err := strconv.Atoi(first); err == nil && v > 0 {
+			n = min(v, maxParamValue)
+		}
+	}
+
+	switch final {
+	case 'C':
+		s.moveTo(s.col + n)
+	case 'D':
+		s.col -= n
+		if s.col < 0 {
+			s.col = 0
+		}
+	case 'G':
+		s.moveTo(n - 1)
+	case 'K':
+		mode := 0
+		if raw != "" {
+			mode, _ = strconv.Atoi(raw)
+		}
+		switch mode {
+		case 0:
+			if s.col < len(s.line) {
+				s.line = s.line[:s.col]
+				s.edit(s.col)
+			}
+		case 1:
+			for i := 0; i <= s.col && i < len(s.line); i++ {
+				s.line[i] = ' '
+			}
+			if len(s.line) > 0 {
+				s.edit(0)
+			}
+		case 2:
+			if len(s.line) > 0 {
+				s.line = s.line[:0]
+				s.edit(0)
+			}
+		}
+	}
+}
+
+// moveTo moves the column. The line is padded with spaces once text is
+// written past its end.
+func (s *Sanitizer) moveTo(col int) {
+	s.col = clamp(col, 0, maxLineLength)
+}
+
+func (s *Sanitizer) put(r rune) {
+	if runeWidth(r) == 0 {
+		return
+	}
+	for len(s.line) < s.col {
+		s.line = append(s.line, ' ')
+	}
+	if s.col < len(s.line) {
+		if s.line[s.col] != r {
+			s.line[s.col] = r
+			s.edit(s.col)
+		}
+	} else {
+		s.line = append(s.line, r)
+	}
+	s.col++
+	if len(s.line) >= maxLineLength {
+		s.endLine()
+	}
+}
+
+func (s *Sanitizer) endLine() {
+	s.emit(strings.TrimRight(string(s.line), " "))
+	s.line = s.line[:0]
+	s.col = 0
+	s.edited = -1
+}
+
+// edit records that the current line changed from column col on.
+func (s *Sanitizer) edit(col int) {
+	if s.edited < 0 || col < s.edited {
+		s.edited = col
+	}
+}
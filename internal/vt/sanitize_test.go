@@ -0,0 +1,28 @@
+package vt
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzStrip(f *testing.F) {
+	for _, seed := range []string{
+		"plain text\n",
+		"\x1b[31mred\x1b[0m\r\nnext",
+		"progress 10%\rprogress 100%\n",
+		"abc\b\bX\x1b[K\n",
+		"a\x1b[9223372036854775807Cb",
+		"a\x1b[9223372036854775807Db",
+		"a\x1b[99999999999999999999Gb",
+		"\x1b]0;title\x07\x1bP1$r\x1b\\text",
+		"\xe2\x82",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		out := Strip(data)
+		if !utf8.ValidString(out) {
+			t.Errorf("Strip(%q) = %q is not valid UTF-8", data, out)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package vt
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Snapshot is a copy of the visible screen and the state needed to render
+// it.
+type Snapshot struct {
+	Cols          int
+	Rows          int
+	CursorX       int
+	CursorY       int
+	CursorVisible bool
+	CursorStyle   Style
+	AltScreen     bool
+	ScrollTop     int
+	ScrollBottom  int
+	Lines         [][]Cell
+
+	// Primary holds the primary screen while the alternate screen is
+	// active, so that a repaint can restore both.
+	Primary [][]Cell
+
+	autoWrap   bool
+	insertMode bool
+	originMode bool
+	modes      map[int]bool
+}
+
+// Snapshot returns a copy of the current screen state.
+func (t *Terminal) Snapshot() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	snap := &Snapshot{
+		Cols:          t.cols,
+		Rows:          t.rows,
+		CursorX:       t.cur.x,
+		CursorY:       t.cur.y,
+		CursorVisible: t.cursorVisible,
+		CursorStyle:   t.cur.style,
+		AltScreen:     t.altScreen,
+		ScrollTop:     t.top,
+		ScrollBottom:  t.bottom,
+		Lines:         copyLines(t.active().lines),
+		autoWrap:      t.autoWrap,
+		insertMode:    t.insertMode,
+		originMode:    t.cur.originMode,
+		modes:         make(map[int]bool, len(t.modes)),
+	}
+	if t.altScreen {
+		snap.Primary = copyLines(t.primary.lines)
+	}
+	for m, on := range t.modes {
+		snap.modes[m] = on
+	}
+	return snap
+}
+
+func copyLines(lines [][]Cell) [][]Cell {
+	out := make([][]Cell, len(lines))
+	for y, line := range lines {
+		out[y] = append([]Cell(nil), line...)
+	}
+	return out
+}
+
+// Text returns the visible screen as plain text, one line per row with
+// trailing blanks removed.
+func (s *Snapshot) Text() string {
+	var sb strings.Builder
+	for y, line := range s.Lines {
+		if y > 0 {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(lineText(line))
+	}
+	return sb.String()
+}
+
+func lineText(line []Cell) string {
+	var sb strings.Builder
+	for _, c := range line {
+		if c.Width == 0 {
+			continue
+		}
+		sb.WriteRune(c.Rune)
+	}
+	return strings.TrimRight(sb.String(), " ")
+}
+
+// ANSI returns an escape sequence stream that repaints the snapshot on a
+// freshly reset terminal of the same size: screen contents, attributes,
+// alternate screen, scroll region, cursor position and visibility, current
+// rendition and the input-related terminal modes.
+func (s *Snapshot) ANSI() string {
+	var sb strings.Builder
+	sb.WriteString("\x1b[!p\x1b[?1049l\x1b[0m\x1b[H\x1b[2J")
+
+	if s.AltScreen {
+		writeLines(&sb, s.Primary)
+		sb.WriteString("\x1b[?1049h\x1b[0m\x1b[H\x1b[2J")
+	}
+	writeLines(&sb, s.Lines)
+
+	if s.ScrollTop != 0 || s.ScrollBottom != s.Rows-1 {
+		sb.WriteString("\x1b[" + strconv.Itoa(s.ScrollTop+1) + ";" + strconv.Itoa(s.ScrollBottom+1) + "r")
+	}
+	if s.originMode {
+		sb.WriteString("\x1b[?6h")
+	}
+	if !s.autoWrap {
+		sb.WriteString("\x1b[?7l")
+	}
+	if s.insertMode {
+		sb.WriteString("\x1b[4h")
+	}
+	for _, m := range replayedModes {
+		if s.modes[m] {
+			sb.WriteString("\x1b[?" + strconv.Itoa(m) + "h")
+		}
+	}
+
+	y := s.CursorY
+	if s.originMode {
+		y -= s.ScrollTop
+	}
+	sb.WriteString("\x1b[" + strconv.Itoa(y+1) + ";" + strconv.Itoa(s.CursorX+1) + "H")
+	sb.WriteString(s.CursorStyle.sgr())
+	if s.CursorVisible {
+		sb.WriteString("\x1b[?25h")
+	} else {
+		sb.WriteString("\x1b[?25l")
+	}
+	return sb.String()
+}
+
+// writeLines paints lines on a cleared screen, positioning the cursor at the
+// start of each row so that autowrap never comes into play.
+func writeLines(sb *strings.Builder, lines [][]Cell) {
+	cur := Style{}
+	for y, line := range lines {
+		end := len(line)
+		for end > 0 && line[end-1] == blank(Style{}) {
+			end--
+		}
+		if end == 0 {
+			continue
+		}
+		sb.WriteString("\x1b[" + strconv.Itoa(y+1) + ";1H")
+		for _, c := range line[:end] {
+			if c.Width == 0 {
+				continue
+			}
+			if c.Style != cur {
+				sb.WriteString(c.Style.sgr())
+				cur = c.Style
+			}
+			sb.WriteRune(c.Rune)
+		}
+	}
+	if cur != (Style{}) {
+		sb.WriteString("\x1b[0m")
+	}
+}
+
+// CellJSON is the JSON representation of a cell. Colors are empty for the
+// default color, a palette index such as "1" or "208", or "#rrggbb".
+type CellJSON struct {
+	Char      string `json:"char"`
+	Wide      bool   `json:"wide,omitempty"`
+	FG        string `json:"fg,omitempty"`
+	BG        string `json:"bg,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Faint     bool   `json:"faint,omitempty"`
+	Italic    bool   `json:"italic,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	Blink     bool   `json:"blink,omitempty"`
+	Inverse   bool   `json:"inverse,omitempty"`
+	Hidden    bool   `json:"hidden,omitempty"`
+	Strike    bool   `json:"strike,omitempty"`
+}
+
+// Cells returns the visible screen as rows of JSON-friendly cells. The
+// continuation cell of a wide character has an empty Char.
+func (s *Snapshot) Cells() [][]CellJSON {
+	rows := make([][]CellJSON, len(s.Lines))
+	for y, line := range s.Lines {
+		row := make([]CellJSON, len(line))
+		for x, c := range line {
+			a := c.Style.Attrs
+			row[x] = CellJSON{
+				Wide:      c.Width == 2,
+				FG:        c.Style.FG.String(),
+				BG:        c.Style.BG.String(),
+				Bold:      a&AttrBold != 0,
+				Faint:     a&AttrFaint != 0,
+				Italic:    a&AttrItalic != 0,
+				Underline: a&AttrUnderline != 0,
+				Blink:     a&AttrBlink != 0,
+				Inverse:   a&AttrInverse != 0,
+				Hidden:    a&AttrHidden != 0,
+				Strike:    a&AttrStrike != 0,
+			}
+			if c.Width != 0 {
+				row[x].Char = string(c.Rune)
+			}
+		}
+		rows[y] = row
+	}
+	return rows
+}
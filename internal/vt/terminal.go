@@ -0,0 +1,397 @@
+package vt
+
+import (
+	"sync"
+)
+
+// DefaultCols and DefaultRows are the size used when a terminal is created
+// with non-positive dimensions.
+const (
+	DefaultCols = 80
+	DefaultRows = 24
+)
+
+// MaxCols and MaxRows bound the size of a terminal. Larger sizes are
+// clamped, so that a bogus size cannot make the screen take all memory.
+const (
+	MaxCols = 1000
+	MaxRows = 1000
+)
+
+// replayedModes are the DEC private modes that affect how the host terminal
+// encodes input. They are tracked so that a repaint restores them.
+var replayedModes = []int{1, 1000, 1002, 1003, 1004, 1006, 2004}
+
+// cursor is the cursor position and rendition, as saved by DECSC.
+type cursor struct {
+	x, y        int
+	style       Style
+	wrapPending bool
+	originMode  bool
+}
+
+// screen is one of the two screen buffers.
+type screen struct {
+	lines [][]Cell
+	saved cursor
+}
+
+func newScreen(cols, rows int) *screen {
+	sc := &screen{lines: make([][]Cell, rows)}
+	for y := range sc.lines {
+		sc.lines[y] = blankLine(cols, Style{})
+	}
+	return sc
+}
+
+func blankLine(cols int, style Style) []Cell {
+	line := make([]Cell, cols)
+	for x := range line {
+		line[x] = blank(style)
+	}
+	return line
+}
+
+// Terminal is a VT100/xterm state machine. Output from a PTY is fed to it
+// with Write, and the resulting screen can be inspected at any time. It is
+// safe for concurrent use.
+type Terminal struct {
+	mu sync.Mutex
+
+	cols, rows int
+	primary    *screen
+	alternate  *screen
+	cur        cursor
+	top        int
+	bottom     int
+	tabs       []bool
+
+	altScreen     bool
+	autoWrap      bool
+	insertMode    bool
+	cursorVisible bool
+	modes         map[int]bool
+	lastRune      rune
+
+	parser parser
+}
+
+// New creates a terminal with the given size.
+func New(cols, rows int) *Terminal {
+	if cols <= 0 {
+		cols = DefaultCols
+	}
+	if rows <= 0 {
+		rows = DefaultRows
+	}
+	cols, rows = min(cols, MaxCols), min(rows, MaxRows)
+	t := &Terminal{cols: cols, rows: rows}
+	t.reset()
+	return t
+}
+
+// reset performs a full terminal reset (RIS).
+func (t *Terminal) reset() {
+	t.primary = newScreen(t.cols, t.rows)
+	t.alternate = newScreen(t.cols, t.rows)
+	t.cur = cursor{}
+	t.top = 0
+	t.bottom = t.rows - 1
+	t.altScreen = false
+	t.autoWrap = true
+	t.insertMode = false
+	t.cursorVisible = true
+	t.modes = make(map[int]bool)
+	t.lastRune = 0
+	t.resetTabs()
+}
+
+func (t *Terminal) resetTabs() {
+	t.tabs = make([]bool, t.cols)
+	for x := 8; x < t.cols; x += 8 {
+		t.tabs[x] = true
+	}
+}
+
+// Write feeds PTY output to the terminal. It never fails.
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, b := range p {
+		t.parser.feed(t, b)
+	}
+	return len(p), nil
+}
+
+// Size returns the terminal dimensions.
+func (t *Terminal) Size() (cols, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cols, t.rows
+}
+
+// Resize changes the terminal dimensions. Lines are truncated or padded on
+// the right; when the screen shrinks below the cursor, lines scroll off the
+// top so that the cursor line stays visible.
+func (t *Terminal) Resize(cols, rows int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cols, rows = min(cols, MaxCols), min(rows, MaxRows)
+	if cols <= 0 || rows <= 0 || (cols == t.cols && rows == t.rows) {
+		return
+	}
+
+	for _, sc := range []*screen{t.primary, t.alternate} {
+		shift := 0
+		if sc == t.active() && t.cur.y >= rows {
+			shift = t.cur.y - rows + 1
+		}
+		lines := make([][]Cell, rows)
+		for y := range lines {
+			src := y + shift
+			if src < len(sc.lines) {
+				lines[y] = resizeLine(sc.lines[src], cols)
+			} else {
+				lines[y] = blankLine(cols, Style{})
+			}
+		}
+		sc.lines = lines
+		if sc == t.active() {
+			t.cur.y -= shift
+		}
+	}
+
+	t.cols, t.rows = cols, rows
+	t.top, t.bottom = 0, rows-1
+	t.cur.x = clamp(t.cur.x, 0, cols-1)
+	t.cur.y = clamp(t.cur.y, 0, rows-1)
+	t.cur.wrapPending = false
+	t.resetTabs()
+}
+
+func resizeLine(line []Cell, cols int) []Cell {
+	if len(line) >= cols {
+		out := line[:cols:cols]
+		// Do not leave half of a wide character at the edge.
+		if cols > 0 && out[cols-1].Width == 2 {
+			out[cols-1] = blank(out[cols-1].Style)
+		}
+		return out
+	}
+	out := make([]Cell, cols)
+	copy(out, line)
+	for x := len(line); x < cols; x++ {
+		out[x] = blank(Style{})
+	}
+	return out
+}
+
+func (t *Terminal) active() *screen {
+	if t.altScreen {
+		return t.alternate
+	}
+	return t.primary
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// print writes a character at the cursor and advances it.
+func (t *Terminal) print(r rune) {
+	w := runeWidth(r)
+	if w == 0 {
+		return
+	}
+	t.lastRune = r
+
+	if t.cur.wrapPending && t.autoWrap {
+		t.cur.x = 0
+		t.index()
+	}
+	t.cur.wrapPending = false
+
+	if w == 2 && t.cur.x == t.cols-1 {
+		if !t.autoWrap || t.cols < 2 {
+			return
+		}
+		t.active().lines[t.cur.y][t.cur.x] = blank(t.cur.style)
+		t.cur.x = 0
+		t.index()
+	}
+
+	line := t.active().lines[t.cur.y]
+	if t.insertMode {
+		copy(line[t.cur.x+w:], line[t.cur.x:])
+	}
+	t.clearWide(line, t.cur.x)
+	line[t.cur.x] = Cell{Rune: r, Width: w, Style: t.cur.style}
+	if w == 2 {
+		t.clearWide(line, t.cur.x+1)
+		line[t.cur.x+1] = Cell{Width: 0, Style: t.cur.style}
+	}
+
+	t.cur.x += w
+	if t.cur.x >= t.cols {
+		t.cur.x = t.cols - 1
+		t.cur.wrapPending = true
+	}
+}
+
+// clearWide blanks the other half of a wide character that is about to be
+// partially overwritten at column x.
+func (t *Terminal) clearWide(line []Cell, x int) {
+	switch line[x].Width {
+	case 0:
+		if x > 0 && line[x-1].Width == 2 {
+			line[x-1] = blank(line[x-1].Style)
+		}
+	case 2:
+		if x+1 < len(line) {
+			line[x+1] = blank(line[x+1].Style)
+		}
+	}
+}
+
+// index moves the cursor down one line, scrolling the region if the cursor
+// is on its bottom margin.
+func (t *Terminal) index() {
+	if t.cur.y == t.bottom {
+		t.scrollUp(1)
+	} else if t.cur.y < t.rows-1 {
+		t.cur.y++
+	}
+}
+
+// reverseIndex moves the cursor up one line, scrolling the region down if
+// the cursor is on its top margin.
+func (t *Terminal) reverseIndex() {
+	if t.cur.y == t.top {
+		t.scrollDown(1)
+	} else if t.cur.y > 0 {
+		t.cur.y--
+	}
+}
+
+// scrollUp scrolls the lines of the scroll region up by n.
+func (t *Terminal) scrollUp(n int) {
+	t.deleteLines(t.top, n)
+}
+
+// scrollDown scrolls the lines of the scroll region down by n.
+func (t *Terminal) scrollDown(n int) {
+	t.insertLines(t.top, n)
+}
+
+// insertLines inserts n blank lines at row y, pushing lines below it towards
+// the bottom margin.
+func (t *Terminal) insertLines(y, n int) {
+	lines := t.active().lines
+	n = clamp(n, 0, t.bottom-y+1)
+	copy(lines[y+n:t.bottom+1], lines[y:t.bottom+1-n])
+	for i := y; i < y+n; i++ {
+		lines[i] = blankLine(t.cols, t.cur.style)
+	}
+}
+
+// deleteLines deletes n lines at row y, pulling lines below it up from the
+// bottom margin.
+func (t *Terminal) deleteLines(y, n int) {
+	lines := t.active().lines
+	n = clamp(n, 0, t.bottom-y+1)
+	copy(lines[y:t.bottom+1-n], lines[y+n:t.bottom+1])
+	for i := t.bottom + 1 - n; i <= t.bottom; i++ {
+		lines[i] = blankLine(t.cols, t.cur.style)
+	}
+}
+
+// eraseCells blanks columns [from, to) of row y.
+func (t *Terminal) eraseCells(y, from, to int) {
+	line := t.active().lines[y]
+	from = clamp(from, 0, t.cols)
+	to = clamp(to, 0, t.cols)
+	for x := from; x < to; x++ {
+		line[x] = blank(t.cur.style)
+	}
+	if from > 0 && from < t.cols && line[from-1].Width == 2 {
+		line[from-1] = blank(line[from-1].Style)
+	}
+	if to < t.cols && line[to].Width == 0 {
+		line[to] = blank(line[to].Style)
+	}
+}
+
+// moveTo moves the cursor to a position relative to the origin, which is the
+// top of the scroll region in origin mode.
+func (t *Terminal) moveTo(x, y int) {
+	if t.cur.originMode {
+		y = clamp(y+t.top, t.top, t.bottom)
+	} else {
+		y = clamp(y, 0, t.rows-1)
+	}
+	t.cur.x = clamp(x, 0, t.cols-1)
+	t.cur.y = y
+	t.cur.wrapPending = false
+}
+
+func (t *Terminal) saveCursor() {
+	t.active().saved = t.cur
+}
+
+func (t *Terminal) restoreCursor() {
+	t.cur = t.active().saved
+	t.cur.x = clamp(t.cur.x, 0, t.cols-1)
+	t.cur.y = clamp(t.cur.y, 0, t.rows-1)
+}
+
+// setAltScreen switches between the primary and alternate screen. Mode 1049
+// also saves and restores the cursor and clears the alternate screen.
+func (t *Terminal) setAltScreen(on bool, mode int) {
+	if on == t.altScreen {
+		return
+	}
+	if on {
+		if mode == 1049 {
+			t.saveCursor()
+		}
+		t.altScreen = true
+		if mode == 1049 {
+			t.alternate = newScreen(t.cols, t.rows)
+		}
+		return
+	}
+
+	if mode == 1047 {
+		t.alternate = newScreen(t.cols, t.rows)
+	}
+	t.altScreen = false
+	if mode == 1049 {
+		t.restoreCursor()
+	}
+}
+
+func (t *Terminal) setPrivateMode(mode int, on bool) {
+	switch mode {
+	case 6:
+		t.cur.originMode = on
+		t.moveTo(0, 0)
+	case 7:
+		t.autoWrap = on
+	case 25:
+		t.cursorVisible = on
+	case 47, 1047, 1049:
+		t.setAltScreen(on, mode)
+	default:
+		for _, m := range replayedModes {
+			if m == mode {
+				t.modes[mode] = on
+			}
+		}
+	}
+}
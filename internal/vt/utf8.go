@@ -0,0 +1,23 @@
+package vt
+
+import "unicode/utf8"
+
+// IncompleteUTF8 returns the number of bytes at the end of p that form the
+// start of a UTF-8 sequence whose remaining bytes have not arrived yet.
+// Callers that split a byte stream into chunks hold those bytes back so
+// that multi-byte characters are never broken across chunks.
+func IncompleteUTF8(p []byte) int {
+	// A sequence is at most utf8.UTFMax bytes, so only the last three bytes
+	// can belong to an incomplete one.
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		b := p[len(p)-i]
+		if b&0xc0 == 0x80 {
+			continue
+		}
+		if b >= 0xc0 && !utf8.FullRune(p[len(p)-i:]) {
+			return i
+		}
+		return 0
+	}
+	return 0
+}
@@ -0,0 +1,283 @@
+// Command framebench compares JSON and binary framing of attach streams. It
+// runs a server in-process, and for each framing spawns a session that
+// prints the same colored, ls-like output at a steady rate while an attached
+// client reads it. It reports the bytes that crossed the socket per byte of
+// output, the wall time, and the CPU time of the server and client together.
+//
+// The output is rate-limited because an unthrottled producer outruns a JSON
+// client, which the server then detaches as too slow.
+//
+//	go run ./test/framebench [-size 16] [-rate 8]
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/api"
+)
+
+// The binary frame layout, as documented in the protocol.
+const (
+	frameJSON   = 0
+	frameOutput = 1
+	frameInput  = 2
+	headerSize  = 7
+)
+
+// line is a typical line of colored ls output.
+const line = "drwxr-xr-x  5 user user   4096 Jan  1 12:00 \033[01;34msrc\033[0m\r\n"
+
+// chunkSize is the amount of output printed at a time.
+const chunkSize = 64 << 10
+
+type result struct {
+	output int64
+	wire   int64
+	wall   time.Duration
+	cpu    time.Duration
+	err    string
+}
+
+type message struct {
+	Ok     bool            `json:"ok"`
+	Err    string          `json:"err"`
+	Data   json.RawMessage `json:"data"`
+	Type   string          `json:"type"`
+	Reason string          `json:"reason"`
+}
+
+func main() {
+	sizeMiB := flag.Int("size", 16, "MiB of output per framing")
+	rateMiB := flag.Float64("rate", 8, "MiB/s of output")
+	flag.Parse()
+
+	// Keep the sessions' logs and FIFOs out of the real home directory.
+	home, err := os.MkdirTemp("", "framebench")
+	if err != nil {
+		log.Fatalf("[FrameBench] Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(home)
+	os.Setenv("HOME", home)
+	// Silence the server's per-session logging.
+	log.SetOutput(io.Discard)
+
+	socketPath := filepath.Join(home, "pty.sock")
+	server := api.NewServer(socketPath)
+	go func() {
+		if err := server.Start(); err != nil {
+			fatalf("server failed: %v", err)
+		}
+	}()
+	defer server.Stop()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(socketPath); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	output := filepath.Join(home, "output")
+	chunks := *sizeMiB << 20 / chunkSize
+	data := make([]byte, 0, chunks*chunkSize+len(line))
+	for len(data) < chunks*chunkSize {
+		data = append(data, line...)
+	}
+	if err := os.WriteFile(output, data[:chunks*chunkSize], 0644); err != nil {
+		fatalf("failed to write output file: %v", err)
+	}
+	interval := float64(chunkSize) / (*rateMiB * (1 << 20))
+	command := fmt.Sprintf("stty -echo; for i in $(seq 0 %d); do dd if=%s bs=%d skip=$i count=1 2>/dev/null; sleep %.4f; done; exit\n",
+		chunks-1, output, chunkSize, interval)
+
+	fmt.Printf("%-8s %12s %12s %10s %10s  %s\n", "framing", "output", "wire/output", "wall", "cpu", "")
+	for _, framing := range []string{api.FramingJSON, api.FramingBinary} {
+		r, err := run(socketPath, framing, command)
+		if err != nil {
+			fatalf("%s: %v", framing, err)
+		}
+		fmt.Printf("%-8s %8.1f MiB %12.3f %10s %10s  %s\n", framing,
+			float64(r.output)/(1<<20), float64(r.wire)/float64(r.output),
+			r.wall.Round(time.Millisecond), r.cpu.Round(time.Millisecond), r.err)
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// client speaks either framing on a socket connection.
+type client struct {
+	conn   net.Conn
+	in     *countingReader
+	r      *bufio.Reader
+	binary bool
+}
+
+// send sends a request without waiting for the response.
+func (c *client) send(action string, data interface{}) error {
+	payload, _ := json.Marshal(data)
+	req, _ := json.Marshal(api.Request{Action: action, Data: payload})
+	if c.binary {
+		return c.writeFrame(frameJSON, 0, req)
+	}
+	_, err := c.conn.Write(append(req, '\n'))
+	return err
+}
+
+// request sends a request and waits for its response, skipping frames.
+func (c *client) request(action string, data interface{}) (message, error) {
+	if err := c.send(action, data); err != nil {
+		return message{}, err
+	}
+	for {
+		m, _, err := c.next()
+		if err != nil {
+			return m, err
+		}
+		if m.Type == "" {
+			if !m.Ok {
+				return m, fmt.Errorf("%s: %s", action, m.Err)
+			}
+			return m, nil
+		}
+	}
+}
+
+// next reads the next message. Output is returned as the number of bytes it
+// carries, with an empty message of type "output".
+func (c *client) next() (message, int, error) {
+	var m message
+	if !c.binary {
+		text, err := c.r.ReadBytes('\n')
+		if err != nil {
+			return m, 0, err
+		}
+		if err := json.Unmarshal(text, &m); err != nil {
+			return m, 0, err
+		}
+		var frame api.Frame
+		if m.Type == "output" {
+			json.Unmarshal(text, &frame)
+			return m, len(frame.Data), nil
+		}
+		return m, 0, nil
+	}
+
+	var header [headerSize]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return m, 0, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[3:]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return m, 0, err
+	}
+	if header[0] == frameOutput {
+		return message{Type: "output"}, len(payload), nil
+	}
+	err := json.Unmarshal(payload, &m)
+	return m, 0, err
+}
+
+func (c *client) writeFrame(typ byte, index uint16, payload []byte) error {
+	buf := make([]byte, headerSize+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint16(buf[1:3], index)
+	binary.BigEndian.PutUint32(buf[3:7], uint32(len(payload)))
+	copy(buf[headerSize:], payload)
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func run(socketPath, framing, command string) (result, error) {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return result{}, err
+	}
+	defer conn.Close()
+	in := &countingReader{r: conn}
+	c := &client{conn: conn, in: in, r: bufio.NewReaderSize(in, 64<<10)}
+
+	if _, err := c.request("framing", api.FramingRequest{Mode: framing}); err != nil {
+		return result{}, err
+	}
+	c.binary = framing == api.FramingBinary
+
+	m, err := c.request("spawn", api.SpawnRequest{})
+	if err != nil {
+		return result{}, err
+	}
+	var spawned api.SpawnResponse
+	json.Unmarshal(m.Data, &spawned)
+	defer c.send("kill", api.KillRequest{ID: spawned.ID})
+
+	m, err = c.request("attach", api.AttachRequest{ID: spawned.ID})
+	if err != nil {
+		return result{}, err
+	}
+	var attached api.AttachResponse
+	json.Unmarshal(m.Data, &attached)
+
+	// Let the shell print its prompt before measuring.
+	time.Sleep(200 * time.Millisecond)
+
+	cpuStart := cpuTime()
+	wireStart := in.n
+	start := time.Now()
+	if c.binary {
+		err = c.writeFrame(frameInput, uint16(attached.Index), []byte(command))
+	} else {
+		// The response is skipped while reading output.
+		err = c.send("write", api.WriteRequest{ID: spawned.ID, Data: command})
+	}
+	if err != nil {
+		return result{}, err
+	}
+
+	var r result
+	for {
+		m, n, err := c.next()
+		if err != nil {
+			return r, err
+		}
+		r.output += int64(n)
+		if m.Type == "exit" || m.Type == "detached" {
+			r.err = m.Reason
+			break
+		}
+	}
+	r.wall = time.Since(start)
+	r.cpu = cpuTime() - cpuStart
+	r.wire = in.n - wireStart
+	return r, nil
+}
+
+// cpuTime returns the user and system CPU time used by the process so far.
+func cpuTime() time.Duration {
+	var usage syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "[FrameBench] "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,116 @@
+// Command logbench compares the cost of persisting PTY output to a session
+// log the way the read loop used to (write and fsync every 4 KiB chunk) with
+// the buffered LogWriter in each durability mode. For each mode it writes
+// the same amount of output and reports how long the writer was blocked per
+// chunk, the throughput actually persisted to disk and any output dropped.
+//
+//	go run ./test/logbench [-dir /path/on/target/disk] [-size 64]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/PiranhaCodes/webpty-pty/internal/pty"
+)
+
+const chunkSize = 4096
+
+type result struct {
+	blocked time.Duration
+	total   time.Duration
+	written int64
+}
+
+func main() {
+	dir := flag.String("dir", "", "Directory to write logs to (default: a temporary directory)")
+	sizeMiB := flag.Int("size", 64, "MiB of output to write per mode")
+	flag.Parse()
+
+	if *dir == "" {
+		tmp, err := os.MkdirTemp("", "logbench")
+		if err != nil {
+			log.Fatalf("[LogBench] Failed to create temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmp)
+		*dir = tmp
+	}
+
+	chunk := make([]byte, chunkSize)
+	for i := range chunk {
+		chunk[i] = byte('a' + i%26)
+	}
+	chunks := *sizeMiB * (1 << 20) / chunkSize
+
+	fmt.Printf("%-28s %14s %14s %12s\n", "mode", "blocked/chunk", "persisted", "dropped")
+	report := func(name string, r result, err error) {
+		if err != nil {
+			log.Fatalf("[LogBench] %s: %v", name, err)
+		}
+		total := int64(chunks) * chunkSize
+		fmt.Printf("%-28s %14s %10.1f MiB/s %12d\n", name,
+			r.blocked/time.Duration(chunks),
+			float64(r.written)/r.total.Seconds()/(1<<20),
+			total-r.written)
+	}
+
+	r, err := runSyncPerChunk(filepath.Join(*dir, "previous.log"), chunk, chunks)
+	report("fsync per chunk (previous)", r, err)
+
+	for _, d := range []pty.Durability{pty.DurabilityFlush, pty.DurabilityClose, pty.DurabilityNone} {
+		r, err := runLogWriter(filepath.Join(*dir, string(d)+".log"), chunk, chunks, d)
+		report("LogWriter durability="+string(d), r, err)
+	}
+}
+
+// runSyncPerChunk reproduces the previous read loop behavior.
+func runSyncPerChunk(path string, chunk []byte, chunks int) (result, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return result{}, err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	for i := 0; i < chunks; i++ {
+		if _, err := f.Write(chunk); err != nil {
+			return result{}, err
+		}
+		f.Sync()
+	}
+	elapsed := time.Since(start)
+	return result{blocked: elapsed, total: elapsed, written: fileSize(path)}, nil
+}
+
+func runLogWriter(path string, chunk []byte, chunks int, durability pty.Durability) (result, error) {
+	os.Remove(path)
+	w, err := pty.NewLogWriter(path, pty.LogPolicy{
+		Durability:  durability,
+		BufferLimit: 256 << 20,
+	})
+	if err != nil {
+		return result{}, err
+	}
+
+	start := time.Now()
+	for i := 0; i < chunks; i++ {
+		w.Write(chunk)
+	}
+	blocked := time.Since(start)
+	if err := w.Close(); err != nil {
+		return result{}, err
+	}
+	return result{blocked: blocked, total: time.Since(start), written: fileSize(path)}, nil
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}